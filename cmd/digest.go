@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	slackgo "github.com/slack-go/slack"
+
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/jobs"
+)
+
+// digestEntry sums up the silent runs of a job
+type digestEntry struct {
+	jobType string
+	handle  string
+	runs    int
+	failed  int
+	changes int // runs which added or removed users
+	members int // after the last run
+}
+
+// digest collects the runs without info message until they are posted as summary
+type digest struct {
+	mutex   sync.Mutex
+	since   time.Time
+	entries map[string]*digestEntry // by job name
+}
+
+func newDigest() *digest {
+	return &digest{since: time.Now(), entries: make(map[string]*digestEntry)}
+}
+
+// record adds a run of the job without info message, nothing is recorded without digest
+func (d *digest) record(job jobs.SyncJob, err error) {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	e, ok := d.entries[job.Name()]
+	if !ok {
+		e = &digestEntry{jobType: job.JobType(), handle: job.SlackHandle()}
+		d.entries[job.Name()] = e
+	}
+	summary := job.ChangeSummary()
+	e.runs++
+	e.members = summary.Members
+	if err != nil {
+		e.failed++
+	}
+	if summary.HasChanges() {
+		e.changes++
+	}
+}
+
+// text returns the summary of the recorded runs and resets them, empty if none were recorded
+func (d *digest) text() string {
+	d.mutex.Lock()
+	entries, since := d.entries, d.since
+	d.entries, d.since = make(map[string]*digestEntry), time.Now()
+	d.mutex.Unlock()
+
+	if len(entries) == 0 {
+		return ""
+	}
+	lines := make([]string, 0, len(entries))
+	runs := 0
+	for _, e := range entries {
+		runs += e.runs
+		line := fmt.Sprintf("• %s `%s`: %d run(s), %d with changes, `%d` member(s)", e.jobType, e.handle, e.runs, e.changes, e.members)
+		if e.failed > 0 {
+			line += fmt.Sprintf(", :stop-sign: %d failed", e.failed)
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf(":memo: *Digest* of %d run(s) without info message since %s\n%s", runs, since.Format(time.RFC822), strings.Join(lines, "\n"))
+}
+
+// post posts the summary of the recorded runs to the info channel, nothing is posted if no run was recorded
+func (d *digest) post(ctx context.Context, slackClient *slackclient.Client) error {
+	text := d.text()
+	if text == "" {
+		return nil
+	}
+	return slackClient.PostMessage(ctx, slackgo.MsgOptionText(text, false))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/jobs"
+	"github.com/sapcc/pagerduty2slack/internal/retry"
+)
+
+// jobDefinition describes a configured job
+type jobDefinition struct {
+	key     string                       // identifies the job across config reloads
+	crontab string                       // schedule of the job
+	tz      config.Timezone              // time zone of the schedule
+	timeout time.Duration                // after which a run is cancelled
+	notify  config.NotifyPolicy          // when the info message is posted
+	config  any                          // job settings, compared to detect changes on reload
+	create  func() (jobs.SyncJob, error) // creates the job
+}
+
+// newClients returns the initialized pagerduty and slack clients
+func newClients(ctx context.Context, cfg *config.Config) (*pagerdutyclient.Client, *slackclient.Client, error) {
+	slackClient, err := slackclient.NewClient(ctx, &cfg.Slack, retry.NewPolicy(cfg.Global))
+	if err != nil {
+		return nil, nil, err
+	}
+	pdClient, err := pagerdutyclient.NewClient(ctx, &cfg.Pagerduty, retry.NewPolicy(cfg.Global))
+	if err != nil {
+		return nil, nil, err
+	}
+	return pdClient, slackClient, nil
+}
+
+// newSyncJobs creates all jobs of the config
+func newSyncJobs(cfg *config.Config, dryrun bool, pdClient *pagerdutyclient.Client, slackClient *slackclient.Client) ([]jobs.SyncJob, error) {
+	var syncJobs []jobs.SyncJob
+	for _, d := range jobDefinitions(cfg, dryrun, pdClient, slackClient) {
+		job, err := d.create()
+		if err != nil {
+			return nil, err
+		}
+		syncJobs = append(syncJobs, job)
+	}
+	return syncJobs, nil
+}
+
+// jobDefinitions returns the definitions of all jobs of the config
+func jobDefinitions(cfg *config.Config, dryrun bool, pdClient *pagerdutyclient.Client, slackClient *slackclient.Client) []jobDefinition {
+	// job settings relevant for changes
+	type settings struct {
+		job     any
+		dryrun  bool
+		timeout time.Duration
+	}
+
+	var definitions []jobDefinition
+	keys := make(map[string]int)
+	key := func(jobType, handle string) string {
+		k := fmt.Sprintf("%s/%s", jobType, strings.ToLower(handle))
+		keys[k]++
+		if keys[k] > 1 {
+			k = fmt.Sprintf("%s#%d", k, keys[k])
+		}
+		return k
+	}
+
+	for _, s := range cfg.Jobs.ScheduleSync {
+		s := s
+		definitions = append(definitions, jobDefinition{
+			key:     key(string(jobs.PdScheduleSync), s.ObjectsToSync.SlackGroupHandle),
+			crontab: s.CrontabExpressionForRepetition,
+			tz:      s.Timezone,
+			timeout: cfg.Global.JobDeadline,
+			notify:  s.Notify,
+			config:  settings{job: s, dryrun: dryrun, timeout: cfg.Global.JobDeadline},
+			create: func() (jobs.SyncJob, error) {
+				job, err := jobs.NewScheduleSyncJob(s, dryrun, pdClient, slackClient)
+				if err != nil {
+					return nil, fmt.Errorf("creating job to sync '%s' failed: %w", s.ObjectsToSync.SlackGroupHandle, err)
+				}
+				return job, nil
+			},
+		})
+	}
+	for _, t := range cfg.Jobs.TeamSync {
+		t := t
+		definitions = append(definitions, jobDefinition{
+			key:     key(string(jobs.PdTeamSync), t.ObjectsToSync.SlackGroupHandle),
+			crontab: t.CrontabExpressionForRepetition,
+			tz:      t.Timezone,
+			timeout: cfg.Global.JobDeadline,
+			notify:  t.Notify,
+			config:  settings{job: t, dryrun: dryrun, timeout: cfg.Global.JobDeadline},
+			create: func() (jobs.SyncJob, error) {
+				job, err := jobs.NewTeamSyncJob(t, dryrun, pdClient, slackClient)
+				if err != nil {
+					return nil, fmt.Errorf("creating job to sync '%s' failed: %w", t.ObjectsToSync.SlackGroupHandle, err)
+				}
+				return job, nil
+			},
+		})
+	}
+	for _, e := range cfg.Jobs.PolicySync {
+		e := e
+		definitions = append(definitions, jobDefinition{
+			key:     key(string(jobs.PdEscalationPolicySync), e.ObjectsToSync.SlackGroupHandle),
+			crontab: e.CrontabExpressionForRepetition,
+			tz:      e.Timezone,
+			timeout: cfg.Global.JobDeadline,
+			notify:  e.Notify,
+			config:  settings{job: e, dryrun: dryrun, timeout: cfg.Global.JobDeadline},
+			create: func() (jobs.SyncJob, error) {
+				job, err := jobs.NewEscalationPolicySyncJob(e, dryrun, pdClient, slackClient)
+				if err != nil {
+					return nil, fmt.Errorf("creating job to sync '%s' failed: %w", e.ObjectsToSync.SlackGroupHandle, err)
+				}
+				return job, nil
+			},
+		})
+	}
+	return definitions
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,27 +12,55 @@ import (
 
 	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
+	slackgo "github.com/slack-go/slack"
 
 	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
 	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
 	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/health"
 	"github.com/sapcc/pagerduty2slack/internal/jobs"
+	"github.com/sapcc/pagerduty2slack/internal/metrics"
+	"github.com/sapcc/pagerduty2slack/internal/retry"
+	"github.com/sapcc/pagerduty2slack/internal/validate"
 )
 
 var opts config.Config
 
 func printUsage() {
 	var CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-	_, _ = fmt.Fprintf(CommandLine.Output(), "\n\nUsage of %s:\n", os.Args[0])
+	_, _ = fmt.Fprintf(CommandLine.Output(), "\n\nUsage of %s: %s [flags] [command]\n", os.Args[0], os.Args[0])
 	flag.PrintDefaults()
+	_, _ = fmt.Fprintf(CommandLine.Output(), "\nCommands:\n")
+	_, _ = fmt.Fprintf(CommandLine.Output(), "  validate\tcheck the config and exit; run 'validate -h' for options\n")
+	_, _ = fmt.Fprintf(CommandLine.Output(), "  plan\t\tprint the changes every job would apply to its slack group and exit; run 'plan -h' for options\n")
+	_, _ = fmt.Fprintf(CommandLine.Output(), "  run\t\trun the selected jobs once and exit; run 'run -h' for options\n")
+	_, _ = fmt.Fprintf(CommandLine.Output(), "\nWithout command all jobs are scheduled and run until a shutdown signal is received.\n")
 }
 
 func main() {
 	flag.StringVar(&opts.ConfigFilePath, "config", "./config.yml", "Config file path including file name.")
 	flag.BoolVar(&opts.Global.Write, "write", false, "[true|false] write changes? Overrides config setting!")
+	flag.Usage = printUsage
 	flag.Parse()
 
-	cfg, err := config.NewConfig(opts.ConfigFilePath)
+	switch flag.Arg(0) {
+	case "":
+		serve()
+	case "validate":
+		os.Exit(validateCommand(flag.Args()[1:]))
+	case "plan":
+		os.Exit(planCommand(flag.Args()[1:]))
+	case "run":
+		os.Exit(runCommand(flag.Args()[1:]))
+	default:
+		printUsage()
+		os.Exit(2)
+	}
+}
+
+// serve schedules all jobs and runs them until a shutdown signal is received
+func serve() {
+	cfg, err := loadConfig()
 	if err != nil {
 		printUsage()
 		log.Fatal(err)
@@ -38,88 +68,246 @@ func main() {
 
 	initLogging(cfg.Global.LogLevel)
 
-	slackClient, err := slackclient.NewClient(&cfg.Slack)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// cancelled to abort running jobs on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 
-	pdClient, err := pagerdutyclient.NewClient(&cfg.Pagerduty)
-	if err != nil {
-		log.Fatal(err)
-	}
+	status := health.NewStatus(cfg.Global.MasterDataMaxFailureDuration)
+	go serveHTTP(cfg.Global.ListenAddress, status)
+
+	// read by the cron jobs while the config is reloaded
+	current := newCurrentConfig(cfg)
 
-	c := cron.New(cron.WithLocation(time.UTC))
-	_, err = c.AddFunc("0 * * * *", func() {
-		if err := slackClient.LoadMasterData(); err != nil {
-			log.Warnf("loading slack masterdata failed: %s", err.Error())
+	// the startup blocks until the clients are ready and the jobs ran once, a shutdown signal must cancel it
+	var (
+		slackClient *slackclient.Client
+		pdClient    *pagerdutyclient.Client
+		c           *cron.Cron
+		sched       *scheduler
+	)
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		var err error
+		slackClient, err = slackclient.NewClient(ctx, &cfg.Slack, retry.NewPolicy(cfg.Global))
+		if err != nil {
+			log.Fatal(err)
 		}
-	})
-	if err != nil {
-		log.Fatalf("adding Slack masterdata loading to cron failed: %s", err.Error())
-	}
+		status.SetSlackReady()
 
-	//member sync jobs
-	for _, s := range cfg.Jobs.ScheduleSync {
-		job, err := jobs.NewScheduleSyncJob(s, !cfg.Global.Write, pdClient, slackClient)
+		pdClient, err = pagerdutyclient.NewClient(ctx, &cfg.Pagerduty, retry.NewPolicy(cfg.Global))
 		if err != nil {
-			log.Fatalf("creating job to sync '%s' failed: %s", s.ObjectsToSync.SlackGroupHandle, err.Error())
+			log.Fatal(err)
+		}
+		status.SetPagerdutyReady()
+		if err := pdClient.WarmUpUserCache(ctx); err != nil {
+			log.Warn(err.Error())
 		}
 
-		_, err = c.AddFunc(s.CrontabExpressionForRepetition, func() {
-			err := job.Run()
+		c = cron.New(cron.WithLocation(time.UTC))
+		_, err = c.AddFunc("0 * * * *", withJobDeadline(ctx, current, func(ctx context.Context) {
+			err := slackClient.LoadMasterData(ctx)
+			status.ObserveMasterDataLoad(err)
 			if err != nil {
-				log.Warnf("schedule_sync failed: %s", err.Error())
+				log.Warnf("loading slack masterdata failed: %s", err.Error())
 			}
-			if err = jobs.PostInfoMessage(slackClient, job); err != nil {
-				log.Warnf("posting update to slack failed: %s", err.Error())
+			if err := pdClient.WarmUpUserCache(ctx); err != nil {
+				log.Warn(err.Error())
 			}
-		})
+		}))
 		if err != nil {
-			log.Fatalf("failed to create job: %s", err.Error())
+			log.Fatalf("adding Slack masterdata loading to cron failed: %s", err.Error())
 		}
+
+		sched = newScheduler(ctx, c, slackClient)
+		if err := sched.applyDigest(cfg.Global.DigestCrontabExpression, cfg.Global.Timezone); err != nil {
+			log.Fatalf("adding digest to cron failed: %s", err.Error())
+		}
+		if err := sched.apply(jobDefinitions(&cfg, !cfg.Global.Write, pdClient, slackClient)); err != nil {
+			log.Fatalf("scheduling jobs failed: %s", err.Error())
+		}
+		status.SetSchedulerReady()
+
+		go c.Start()
+
+		if cfg.Global.RunAtStart {
+			sched.runAll()
+		} else {
+			log.Info("cfg.Global.RunAtStart is set to: ", cfg.Global.RunAtStart)
+		}
+	}()
+	if !awaitStartup(started, sig, cancel) {
+		return
+	}
+
+	checksum, err := configChecksum(cfg.ConfigFilePath)
+	if err != nil {
+		log.Warnf("config: %s", err.Error())
 	}
-	//group sync jobs
-	for _, t := range cfg.Jobs.TeamSync {
-		job, err := jobs.NewTeamSyncJob(t, !cfg.Global.Write, pdClient, slackClient)
+	reload := func() {
+		if sum, err := configChecksum(cfg.ConfigFilePath); err == nil {
+			checksum = sum
+		}
+		newCfg, err := reloadConfig(&cfg, sched, pdClient, slackClient)
 		if err != nil {
-			log.Fatalf("creating job to sync '%s' failed: %s", t.ObjectsToSync.SlackGroupHandle, err.Error())
+			log.Warnf("config: reload failed, keeping previous config: %s", err.Error())
+			msg := fmt.Sprintf(":warning: *Reloading config `%s` failed, keeping previous config:*\n```%s```", cfg.ConfigFilePath, err.Error())
+			if err := slackClient.PostMessage(ctx, slackgo.MsgOptionText(msg, false)); err != nil {
+				log.Warnf("posting update to slack failed: %s", err.Error())
+			}
+			return
 		}
-		_, err = c.AddFunc(t.CrontabExpressionForRepetition, func() {
-			err := job.Run()
+		cfg = newCfg
+		current.set(newCfg)
+	}
+
+	reloadTicker := time.NewTicker(cfg.Global.ConfigReloadInterval)
+	defer reloadTicker.Stop()
+	for {
+		select {
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				log.Infof("received %v, reloading config", s.String())
+				refreshCredentials(ctx, pdClient, slackClient)
+				reload()
+				continue
+			}
+			log.Infof("received %v, shutting down", s.String())
+			slackClient.Close()
+			shutdown(c, cancel, sig)
+			return
+		case <-reloadTicker.C:
+			refreshCredentials(ctx, pdClient, slackClient)
+			newChecksum, err := configChecksum(cfg.ConfigFilePath)
 			if err != nil {
-				log.Warnf("adding group members to slack failed: %s", err.Error())
-				return
+				log.Warnf("config: %s", err.Error())
+				continue
 			}
-			if err = jobs.PostInfoMessage(slackClient, job); err != nil {
-				log.Warnf("posting update to slack failed: %s", err.Error())
+			if newChecksum == checksum {
+				continue
 			}
-		})
-		if err != nil {
-			log.Fatalf("failed to schedule job: %s", err)
+			log.Info("config: file changed, reloading config")
+			reload()
 		}
 	}
+}
 
-	go c.Start()
-	defer c.Stop()
-
-	time.Sleep(2000)
-	if cfg.Global.RunAtStart {
-		for rc, e := range c.Entries() {
-			if rc == 0 {
+// awaitStartup waits until the startup finished and returns true. A shutdown signal cancels the startup and false
+// is returned without waiting for it; SIGHUP is ignored until the jobs are scheduled.
+func awaitStartup(started <-chan struct{}, sig <-chan os.Signal, cancel context.CancelFunc) bool {
+	for {
+		select {
+		case <-started:
+			return true
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				log.Infof("received %v during startup, ignored", s.String())
 				continue
 			}
-			log.Debugf("job %d: next run %s; valid: %v", e.ID, e.Next, e.Valid())
-			if e.Valid() {
-				c.Entry(e.ID).WrappedJob.Run()
+			log.Infof("received %v during startup, shutting down", s.String())
+			cancel()
+			return false
+		}
+	}
+}
+
+// shutdown stops scheduling jobs and waits for running jobs to finish. Another shutdown signal cancels the running jobs.
+func shutdown(c *cron.Cron, cancel context.CancelFunc, sig <-chan os.Signal) {
+	stopped := c.Stop()
+	select {
+	case <-stopped.Done():
+		return
+	default:
+		log.Info("waiting for running jobs to finish, send the signal again to cancel them")
+	}
+	for {
+		select {
+		case <-stopped.Done():
+			log.Info("all jobs finished")
+			return
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				continue
 			}
+			log.Infof("received %v, cancelling running jobs", s.String())
+			cancel()
+		}
+	}
+}
+
+// withJobDeadline returns a cron job running f cancelled after the job deadline of the current config
+func withJobDeadline(ctx context.Context, current *currentConfig, f func(ctx context.Context)) func() {
+	return func() {
+		ctx, cancel := context.WithTimeout(ctx, current.get().Global.JobDeadline)
+		defer cancel()
+		f(ctx)
+	}
+}
+
+// loadConfig reads the config given by flag, applies the flags overriding config settings and validates it offline,
+// so the startup and reloads accept the same configs
+func loadConfig() (config.Config, error) {
+	cfg, err := config.NewConfig(opts.ConfigFilePath)
+	if err != nil {
+		return cfg, err
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "write" {
+			cfg.Global.Write = opts.Global.Write
 		}
+	})
+	if report := validate.Offline(&cfg); report.HasErrors() {
+		return cfg, fmt.Errorf("invalid config:\n%s", report.String())
+	}
+	return cfg, nil
+}
+
+// runJob runs the job once cancelled after the timeout, records its metrics and posts the info message according to
+// the notify policy. Runs without info message are recorded in the digest, if any.
+func runJob(ctx context.Context, job jobs.SyncJob, slackClient *slackclient.Client, timeout time.Duration, notify config.NotifyPolicy, silent *digest) error {
+	jobCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := job.Run(jobCtx)
+	metrics.ObserveJobRun(job, err)
+	if err != nil {
+		log.Warnf("%s failed: %s", job.Name(), err.Error())
+	}
+	if !shouldNotify(notify, job, err) {
+		silent.record(job, err)
+		return err
+	}
+	// posted with the parent context, so a timed out job still reports its error
+	if err := jobs.PostInfoMessage(ctx, slackClient, job); err != nil {
+		log.Warnf("posting update to slack failed: %s", err.Error())
+	}
+	return err
+}
+
+// shouldNotify returns true if the info message of the job run is posted according to the policy
+func shouldNotify(notify config.NotifyPolicy, job jobs.SyncJob, err error) bool {
+	switch notify {
+	case config.NotifyNever:
+		return false
+	case config.NotifyOnError:
+		return err != nil
+	case config.NotifyOnChange:
+		return err != nil || job.ChangeSummary().HasChanges()
+	default:
+		return true
+	}
+}
+
+// serveHTTP exposes metrics and health endpoints on the given address
+func serveHTTP(listenAddress string, status *health.Status) {
+	mux := http.NewServeMux()
+	metrics.RegisterHandlers(mux)
+	status.RegisterHandlers(mux)
 
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
-		s := <-sig
-		log.Infof("received %v, shutting down", s.String())
-	} else {
-		log.Info("cfg.Global.RunAtStart is set to: ", cfg.Global.RunAtStart)
+	log.Infof("listening on %s", listenAddress)
+	if err := http.ListenAndServe(listenAddress, mux); err != nil { //nolint:gosec // only serves metrics and probes, no timeouts required
+		log.Fatalf("serving http failed: %s", err.Error())
 	}
 }
 
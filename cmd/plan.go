@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sapcc/pagerduty2slack/internal/jobs"
+)
+
+// planCommand prints the changes every job would apply to its slack group, it returns the exit code
+func planCommand(args []string) int {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	output := fs.String("output", "text", "[text|json] format of the plan")
+	_ = fs.Parse(args) // exits on error
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "plan: %s\n", err.Error())
+		return 1
+	}
+	// keep the plan readable, logs go to stderr anyway
+	initLogging("warn")
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pdClient, slackClient, err := newClients(ctx, &cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "plan: %s\n", err.Error())
+		return 1
+	}
+	syncJobs, err := newSyncJobs(&cfg, true, pdClient, slackClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "plan: %s\n", err.Error())
+		return 1
+	}
+
+	plans, exitCode := planJobs(ctx, syncJobs, cfg.Global.JobDeadline)
+	if err := writePlans(os.Stdout, *output, plans); err != nil {
+		fmt.Fprintf(os.Stderr, "plan: %s\n", err.Error())
+		return 1
+	}
+	return exitCode
+}
+
+// planJobs returns the plans of all jobs and the exit code, 1 if any job failed
+func planJobs(ctx context.Context, syncJobs []jobs.SyncJob, timeout time.Duration) ([]*jobs.Plan, int) {
+	exitCode := 0
+	plans := make([]*jobs.Plan, 0, len(syncJobs))
+	for _, job := range syncJobs {
+		plan, err := planJob(ctx, job, timeout)
+		if err != nil {
+			log.Warnf("plan: %s failed: %s", job.Name(), err.Error())
+			plan = jobs.NewFailedPlan(job, err)
+			exitCode = 1
+		}
+		plans = append(plans, plan)
+	}
+	return plans, exitCode
+}
+
+// writePlans writes the plans in the output format
+func writePlans(w io.Writer, output string, plans []*jobs.Plan) error {
+	switch output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plans); err != nil {
+			return fmt.Errorf("encoding plan failed: %w", err)
+		}
+	default:
+		fmt.Fprint(w, jobs.RenderPlans(plans))
+	}
+	return nil
+}
+
+// planJob returns the plan of the job, cancelled after the timeout
+func planJob(ctx context.Context, job jobs.SyncJob, timeout time.Duration) (*jobs.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return job.Plan(ctx)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/config"
+)
+
+// currentConfig holds the config applied last, so it can be read from other goroutines while it's reloaded
+type currentConfig struct {
+	mutex sync.RWMutex
+	cfg   config.Config
+}
+
+func newCurrentConfig(cfg config.Config) *currentConfig {
+	return &currentConfig{cfg: cfg}
+}
+
+func (c *currentConfig) get() config.Config {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cfg
+}
+
+func (c *currentConfig) set(cfg config.Config) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cfg = cfg
+}
+
+// reloadConfig reads and validates the config like at startup and applies its jobs to the scheduler; on error nothing is changed
+func reloadConfig(current *config.Config, sched *scheduler, pdClient *pagerdutyclient.Client, slackClient *slackclient.Client) (config.Config, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return cfg, err
+	}
+	if err := sched.apply(jobDefinitions(&cfg, !cfg.Global.Write, pdClient, slackClient)); err != nil {
+		return cfg, err
+	}
+	// the digest schedule was validated above, it doesn't fail after the jobs were applied
+	if err := sched.applyDigest(cfg.Global.DigestCrontabExpression, cfg.Global.Timezone); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Global.LogLevel != current.Global.LogLevel {
+		initLogging(cfg.Global.LogLevel)
+	}
+	if !reflect.DeepEqual(withoutSecrets(&cfg), withoutSecrets(current)) || cfg.Global.ListenAddress != current.Global.ListenAddress {
+		log.Warn("config: changes of slack, pagerduty or listen address settings require a restart")
+	}
+	log.Infof("config: reloaded %s", cfg.ConfigFilePath)
+	return cfg, nil
+}
+
+// withoutSecrets returns the slack and pagerduty config without credentials, which are rotated by the clients themselves
+func withoutSecrets(cfg *config.Config) []any {
+	s, p := cfg.Slack, cfg.Pagerduty
+	s.BotSecurityToken, s.UserSecurityToken = "", ""
+	p.AuthToken, p.APIUser = "", ""
+	return []any{s, p}
+}
+
+// refreshCredentials lets the clients re-read rotated credentials
+func refreshCredentials(ctx context.Context, pdClient *pagerdutyclient.Client, slackClient *slackclient.Client) {
+	if err := slackClient.RefreshCredentials(ctx); err != nil {
+		log.Warn(err.Error())
+	}
+	if err := pdClient.RefreshCredentials(ctx); err != nil {
+		log.Warn(err.Error())
+	}
+}
+
+// configChecksum returns the checksum of the config file to detect changes
+func configChecksum(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading configuration file failed: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
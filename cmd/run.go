@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/jobs"
+)
+
+// runCommand runs the selected jobs once, it returns the exit code
+func runCommand(args []string) int {
+	var handles []string
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Func("job", "slack handle of the job(s) to run; repeat the flag or separate by comma for several jobs", func(s string) error {
+		for _, h := range strings.Split(s, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				handles = append(handles, h)
+			}
+		}
+		return nil
+	})
+	all := fs.Bool("all", false, "[true|false] run all jobs")
+	noInfoMessage := fs.Bool("no-info-message", false, "[true|false] do not post the info message to slack")
+	_ = fs.Parse(args) // exits on error
+
+	if *all == (len(handles) > 0) {
+		fmt.Fprintln(os.Stderr, "run: either -job or -all is required")
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run: %s\n", err.Error())
+		return 1
+	}
+	initLogging(cfg.Global.LogLevel)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pdClient, slackClient, err := newClients(ctx, &cfg)
+	if err != nil {
+		log.Errorf("run: %s", err.Error())
+		return 1
+	}
+	syncJobs, err := newSyncJobs(&cfg, !cfg.Global.Write, pdClient, slackClient)
+	if err != nil {
+		log.Errorf("run: %s", err.Error())
+		return 1
+	}
+
+	selected, err := selectJobs(syncJobs, handles, *all)
+	if err != nil {
+		log.Errorf("run: %s", err.Error())
+		return 1
+	}
+
+	notify := config.NotifyAlways
+	if *noInfoMessage {
+		notify = config.NotifyNever
+	}
+	failed := 0
+	for _, job := range selected {
+		if err := runJob(ctx, job, slackClient, cfg.Global.JobDeadline, notify, nil); err != nil {
+			failed++
+		}
+	}
+	log.Infof("run: %d of %d job(s) failed", failed, len(selected))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// selectJobs returns all jobs or the jobs syncing one of the given slack handles, each job once
+func selectJobs(syncJobs []jobs.SyncJob, handles []string, all bool) ([]jobs.SyncJob, error) {
+	if all {
+		return syncJobs, nil
+	}
+
+	var selected []jobs.SyncJob
+	seen := make(map[int]struct{}, len(syncJobs))
+	for _, h := range handles {
+		found := false
+		for i, job := range syncJobs {
+			if !strings.EqualFold(job.SlackHandle(), h) {
+				continue
+			}
+			found = true
+			if _, ok := seen[i]; !ok {
+				seen[i] = struct{}{}
+				selected = append(selected, job)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no job syncs slack handle '%s'", h)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/jobs"
+	"github.com/sapcc/pagerduty2slack/internal/metrics"
+)
+
+// scheduledJob is a job registered at the cron
+type scheduledJob struct {
+	id       cron.EntryID
+	config   any
+	schedule cron.Schedule
+	timeout  time.Duration
+	notify   config.NotifyPolicy
+	job      jobs.SyncJob
+}
+
+// scheduler keeps the cron entries in sync with the configured jobs
+type scheduler struct {
+	mutex       sync.Mutex
+	ctx         context.Context // cancels running jobs on shutdown
+	cron        *cron.Cron
+	slackClient *slackclient.Client
+	digest      *digest                 // records runs without info message, nil if disabled
+	digestID    cron.EntryID            // of posting the digest
+	digestSpec  string                  // crontab expression and time zone of the digest, empty if disabled
+	entries     map[string]scheduledJob // by job definition key
+}
+
+func newScheduler(ctx context.Context, c *cron.Cron, slackClient *slackclient.Client) *scheduler {
+	return &scheduler{
+		ctx:         ctx,
+		cron:        c,
+		slackClient: slackClient,
+		entries:     make(map[string]scheduledJob),
+	}
+}
+
+// applyDigest schedules posting the digest of the runs without info message, it's disabled if the crontab is empty.
+// The recorded runs are kept if only the schedule changes.
+func (s *scheduler) applyDigest(crontab string, tz config.Timezone) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	spec := ""
+	if crontab != "" {
+		spec = fmt.Sprintf("%s %s", crontab, tz)
+	}
+	if spec == s.digestSpec {
+		return nil
+	}
+	var schedule cron.Schedule
+	if spec != "" {
+		var err error
+		if schedule, err = jobs.ParseSchedule(crontab, tz); err != nil {
+			return fmt.Errorf("digest: %w", err)
+		}
+	}
+
+	if s.digestSpec != "" {
+		s.cron.Remove(s.digestID)
+	}
+	s.digestSpec = spec
+	if spec == "" {
+		s.digest = nil
+		log.Info("scheduler: digest disabled")
+		return nil
+	}
+	if s.digest == nil {
+		s.digest = newDigest()
+	}
+	silent := s.digest
+	s.digestID = s.cron.Schedule(schedule, cron.FuncJob(func() {
+		if err := silent.post(s.ctx, s.slackClient); err != nil {
+			log.Warnf("posting digest to slack failed: %s", err.Error())
+		}
+	}))
+	log.Infof("scheduler: digest scheduled, next post %s", schedule.Next(time.Now()))
+	return nil
+}
+
+// currentDigest returns the digest recording the runs without info message, nil if disabled
+func (s *scheduler) currentDigest() *digest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.digest
+}
+
+// apply adds new, removes deleted and replaces changed jobs; unchanged jobs are not touched.
+// If any job can't be created, nothing is changed.
+func (s *scheduler) apply(definitions []jobDefinition) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	desired := make(map[string]struct{}, len(definitions))
+	created := make(map[string]scheduledJob)
+	for _, d := range definitions {
+		desired[d.key] = struct{}{}
+		if e, ok := s.entries[d.key]; ok && reflect.DeepEqual(e.config, d.config) {
+			continue
+		}
+		schedule, err := jobs.ParseSchedule(d.crontab, d.tz)
+		if err != nil {
+			return fmt.Errorf("job '%s': %w", d.key, err)
+		}
+		job, err := d.create()
+		if err != nil {
+			return err
+		}
+		created[d.key] = scheduledJob{config: d.config, schedule: schedule, timeout: d.timeout, notify: d.notify, job: job}
+	}
+
+	removed, updated := 0, 0
+	for key, e := range s.entries {
+		_, isDesired := desired[key]
+		replacement, isChanged := created[key]
+		switch {
+		case !isDesired:
+			removed++
+		case isChanged:
+			updated++
+		default:
+			continue
+		}
+		// the series of a replaced job are kept if it's still reported with the same labels
+		if e.job != nil && (!isChanged || !sameSeries(e.job, replacement.job)) {
+			metrics.DeleteJob(e.job)
+		}
+		s.cron.Remove(e.id)
+		delete(s.entries, key)
+		log.Debugf("scheduler: removed job '%s'", key)
+	}
+	for key, e := range created {
+		key, job, timeout, notify := key, e.job, e.timeout, e.notify
+		// a run outlasting the next tick or colliding with runAll must not overlap with itself, the job keeps its state
+		// of the run until the info message is posted
+		running := new(sync.Mutex)
+		e.id = s.cron.Schedule(e.schedule, cron.FuncJob(func() {
+			if !running.TryLock() {
+				log.Warnf("scheduler: skipped job '%s', the previous run is still running", key)
+				return
+			}
+			defer running.Unlock()
+			_ = runJob(s.ctx, job, s.slackClient, timeout, notify, s.currentDigest()) // errors are logged and posted
+		}))
+		s.entries[key] = e
+		log.Debugf("scheduler: scheduled job '%s', next run %s", key, e.schedule.Next(time.Now()))
+	}
+
+	log.Infof("scheduler: %d job(s) added, %d updated, %d removed, %d scheduled", len(created)-updated, updated, removed, len(s.entries))
+	return nil
+}
+
+// sameSeries is true if the metrics of both jobs have the same labels
+func sameSeries(a, b jobs.SyncJob) bool {
+	return b != nil && a.Name() == b.Name() && a.JobType() == b.JobType() && a.SlackHandle() == b.SlackHandle()
+}
+
+// runAll runs all scheduled jobs once, ordered by key; jobs still running are skipped
+func (s *scheduler) runAll() {
+	s.mutex.Lock()
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]scheduledJob, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, s.entries[key])
+	}
+	s.mutex.Unlock()
+
+	for _, e := range entries {
+		s.cron.Entry(e.id).WrappedJob.Run()
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/jobs"
+	"github.com/sapcc/pagerduty2slack/internal/metrics"
+)
+
+// fakeJob implements the parts of a job used to decide about and summarize info messages
+type fakeJob struct {
+	jobs.SyncJob
+	handle  string
+	summary jobs.ChangeSummary
+}
+
+func (f fakeJob) Name() string                      { return "job " + f.handle }
+func (f fakeJob) JobType() string                   { return string(jobs.PdScheduleSync) }
+func (f fakeJob) SlackHandle() string               { return f.handle }
+func (f fakeJob) ChangeSummary() jobs.ChangeSummary { return f.summary }
+
+func definition(key, crontab, setting string, created *int) jobDefinition {
+	return jobDefinition{
+		key:     key,
+		crontab: crontab,
+		config:  setting,
+		create: func() (jobs.SyncJob, error) {
+			*created++
+			return nil, nil
+		},
+	}
+}
+
+func TestSchedulerApply(t *testing.T) {
+	s := newScheduler(context.Background(), cron.New(), nil)
+	created := 0
+
+	err := s.apply([]jobDefinition{
+		definition("a", "1 * * * *", "a", &created),
+		definition("b", "2 * * * *", "b", &created),
+		definition("c", "3 * * * *", "c", &created),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, created)
+	assert.Len(t, s.cron.Entries(), 3)
+	idA, idB := s.entries["a"].id, s.entries["b"].id
+
+	// a unchanged, b changed, c removed, d added
+	created = 0
+	err = s.apply([]jobDefinition{
+		definition("a", "1 * * * *", "a", &created),
+		definition("b", "2 * * * *", "b changed", &created),
+		definition("d", "4 * * * *", "d", &created),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, created)
+	assert.Len(t, s.cron.Entries(), 3)
+	assert.Equal(t, idA, s.entries["a"].id)
+	assert.NotEqual(t, idB, s.entries["b"].id)
+	assert.NotContains(t, s.entries, "c")
+	assert.Contains(t, s.entries, "d")
+}
+
+// jobSeries returns the number of metric series of the job with the name
+func jobSeries(t *testing.T, name string) int {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	count := 0
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "job" && l.GetValue() == name {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+func TestSchedulerApplyDeletesMetrics(t *testing.T) {
+	s := newScheduler(context.Background(), cron.New(), nil)
+	job := func(key, handle, setting string) jobDefinition {
+		return jobDefinition{key: key, crontab: "1 * * * *", config: setting, create: func() (jobs.SyncJob, error) {
+			return fakeJob{handle: handle}, nil
+		}}
+	}
+	observe := func(handle string) {
+		metrics.ObserveJobRun(fakeJob{handle: handle}, nil)
+		metrics.SetUnmatchedUsers(fakeJob{handle: handle}, 1)
+	}
+
+	err := s.apply([]jobDefinition{job("kept", "metrics-kept", "a"), job("renamed", "metrics-old", "a"), job("removed", "metrics-removed", "a")})
+	assert.NoError(t, err)
+	for _, handle := range []string{"metrics-kept", "metrics-old", "metrics-removed"} {
+		observe(handle)
+		assert.Equal(t, 3, jobSeries(t, "job "+handle))
+	}
+
+	// kept changes its config but not its labels, renamed gets another handle
+	err = s.apply([]jobDefinition{job("kept", "metrics-kept", "b"), job("renamed", "metrics-new", "b")})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, jobSeries(t, "job metrics-kept"))
+	assert.Zero(t, jobSeries(t, "job metrics-old"))
+	assert.Zero(t, jobSeries(t, "job metrics-removed"))
+}
+
+func TestSchedulerApplyInvalidKeepsJobs(t *testing.T) {
+	s := newScheduler(context.Background(), cron.New(), nil)
+	created := 0
+
+	assert.NoError(t, s.apply([]jobDefinition{definition("a", "1 * * * *", "a", &created)}))
+	err := s.apply([]jobDefinition{
+		definition("b", "2 * * * *", "b", &created),
+		definition("c", "invalid", "c", &created),
+	})
+	assert.Error(t, err)
+	assert.Contains(t, s.entries, "a")
+	assert.NotContains(t, s.entries, "b")
+	assert.Len(t, s.cron.Entries(), 1)
+}
+
+func TestShouldNotify(t *testing.T) {
+	unchanged := fakeJob{handle: "a", summary: jobs.ChangeSummary{Members: 1}}
+	changed := fakeJob{handle: "a", summary: jobs.ChangeSummary{Added: []jobs.SummaryUser{{SlackID: "W1"}}, Members: 2}}
+	failure := errors.New("failed")
+
+	assert.True(t, shouldNotify(config.NotifyAlways, unchanged, nil))
+	assert.False(t, shouldNotify(config.NotifyOnChange, unchanged, nil))
+	assert.True(t, shouldNotify(config.NotifyOnChange, changed, nil))
+	assert.True(t, shouldNotify(config.NotifyOnChange, unchanged, failure))
+	assert.False(t, shouldNotify(config.NotifyOnError, changed, nil))
+	assert.True(t, shouldNotify(config.NotifyOnError, unchanged, failure))
+	assert.False(t, shouldNotify(config.NotifyNever, changed, failure))
+}
+
+func TestSelectJobs(t *testing.T) {
+	type testCase struct {
+		name     string
+		handles  []string
+		all      bool
+		expected []string
+		err      string
+	}
+	testCases := []testCase{
+		{
+			name:     "all",
+			all:      true,
+			expected: []string{"a", "b", "a"},
+		},
+		{
+			name:     "all jobs of a handle",
+			handles:  []string{"a"},
+			expected: []string{"a", "a"},
+		},
+		{
+			name:     "in order of the handles",
+			handles:  []string{"b", "a"},
+			expected: []string{"b", "a", "a"},
+		},
+		{
+			name:     "duplicate handles",
+			handles:  []string{"b", "B", "b"},
+			expected: []string{"b"},
+		},
+		{
+			name:    "unknown handle",
+			handles: []string{"a", "unknown"},
+			err:     "no job syncs slack handle 'unknown'",
+		},
+	}
+	syncJobs := []jobs.SyncJob{fakeJob{handle: "a"}, fakeJob{handle: "b"}, fakeJob{handle: "a"}}
+
+	for _, test := range testCases {
+		selected, err := selectJobs(syncJobs, test.handles, test.all)
+		if test.err != "" {
+			if assert.Error(t, err, test.name) {
+				assert.Equal(t, test.err, err.Error(), test.name)
+			}
+			continue
+		}
+		var handles []string
+		for _, job := range selected {
+			handles = append(handles, job.SlackHandle())
+		}
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, handles, test.name)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	d := newDigest()
+	assert.Empty(t, d.text())
+
+	d.record(fakeJob{handle: "b", summary: jobs.ChangeSummary{Members: 1}}, nil)
+	d.record(fakeJob{handle: "a", summary: jobs.ChangeSummary{Members: 2}}, nil)
+	d.record(fakeJob{handle: "a", summary: jobs.ChangeSummary{Members: 3, Removed: []jobs.SummaryUser{{SlackID: "W1"}}}}, errors.New("failed"))
+
+	text := d.text()
+	assert.Contains(t, text, "3 run(s) without info message")
+	assert.Contains(t, text, "PD Schedule `a`: 2 run(s), 1 with changes, `3` member(s), :stop-sign: 1 failed\n• PD Schedule `b`: 1 run(s)")
+	assert.Empty(t, d.text(), "recorded runs are reset")
+
+	var disabled *digest
+	disabled.record(fakeJob{handle: "a"}, nil)
+}
+
+func TestSchedulerApplyDigest(t *testing.T) {
+	c := cron.New()
+	s := newScheduler(context.Background(), c, nil)
+
+	assert.NoError(t, s.applyDigest("0 9 * * *", "UTC"))
+	if !assert.Len(t, c.Entries(), 1) || !assert.NotNil(t, s.currentDigest()) {
+		return
+	}
+	id, silent := s.digestID, s.currentDigest()
+	silent.record(fakeJob{handle: "a"}, nil)
+
+	assert.NoError(t, s.applyDigest("0 9 * * *", "UTC"))
+	assert.Equal(t, id, s.digestID, "unchanged digest is not rescheduled")
+
+	assert.NoError(t, s.applyDigest("0 9 * * *", "Europe/Berlin"))
+	assert.Len(t, c.Entries(), 1)
+	assert.NotEqual(t, id, s.digestID)
+	assert.Same(t, silent, s.currentDigest(), "recorded runs are kept")
+	assert.Contains(t, silent.text(), "1 run(s)")
+
+	assert.Error(t, s.applyDigest("invalid", "UTC"))
+	assert.Equal(t, "0 9 * * * Europe/Berlin", s.digestSpec)
+
+	assert.NoError(t, s.applyDigest("", "UTC"))
+	assert.Empty(t, c.Entries())
+	assert.Nil(t, s.currentDigest())
+}
+
+// run with -race: the cron job reads the deadline while the config is reloaded
+func TestWithJobDeadlineDuringReload(t *testing.T) {
+	cfg := config.Config{}
+	cfg.Global.JobDeadline = time.Minute
+	current := newCurrentConfig(cfg)
+	runs := 0
+	job := withJobDeadline(context.Background(), current, func(ctx context.Context) {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+		runs++
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			job()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			reloaded := cfg
+			reloaded.Global.JobDeadline = time.Duration(i+1) * time.Second
+			current.set(reloaded)
+		}
+	}()
+	wg.Wait()
+	assert.Equal(t, 100, runs)
+	assert.Equal(t, 100*time.Second, current.get().Global.JobDeadline)
+}
+
+func TestAwaitStartup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 2)
+	sig <- syscall.SIGHUP
+	sig <- syscall.SIGTERM
+	assert.False(t, awaitStartup(make(chan struct{}), sig, cancel))
+	assert.Error(t, ctx.Err(), "startup must be cancelled")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	close(started)
+	assert.True(t, awaitStartup(started, make(chan os.Signal), cancel))
+	assert.NoError(t, ctx.Err())
+}
+
+func TestLoadAndReloadConfig(t *testing.T) {
+	t.Setenv("SLACK_BOT_TOKEN", "bot")
+	t.Setenv("SLACK_USER_TOKEN", "user")
+	t.Setenv("PAGERDUTY_TOKEN", "token")
+	t.Setenv("PAGERDUTY_USER", "api@example.com")
+	path := filepath.Join(t.TempDir(), "config.yml")
+	defer func(previous string) { opts.ConfigFilePath = previous }(opts.ConfigFilePath)
+	opts.ConfigFilePath = path
+	writeConfig := func(handles ...string) {
+		content := "jobs:\n  pd-schedules-on-duty-to-slack-group:\n"
+		for _, h := range handles {
+			content += fmt.Sprintf("    - crontabExpressionForRepetition: 1 * * * *\n      syncObjects:\n        slackGroupHandle: %s\n        pdObjectIds: [P1]\n", h)
+		}
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	// duplicate handles are rejected at startup like on reload
+	writeConfig("oncall", "OnCall")
+	_, err := loadConfig()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "'OnCall' is already synced by pd-schedules-on-duty-to-slack-group[0]")
+	}
+
+	writeConfig("oncall")
+	cfg, err := loadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	s := newScheduler(context.Background(), cron.New(), nil)
+	assert.NoError(t, s.apply(jobDefinitions(&cfg, true, nil, nil)))
+
+	writeConfig("oncall", "backup")
+	cfg, err = reloadConfig(&cfg, s, nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, s.entries, 2)
+
+	writeConfig("oncall", "backup", "Backup")
+	_, err = reloadConfig(&cfg, s, nil, nil)
+	assert.Error(t, err)
+	assert.Len(t, s.entries, 2, "jobs are kept if the reload fails")
+}
+
+// blockingJob runs until it's released and counts its runs without lock, like the sync jobs keep their state
+type blockingJob struct {
+	fakeJob
+	runs    *int
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b blockingJob) Run(_ context.Context) error {
+	*b.runs++
+	b.started <- struct{}{}
+	<-b.release
+	return nil
+}
+
+// run with -race: a job must not run again while it's still running
+func TestSchedulerSkipsRunningJob(t *testing.T) {
+	s := newScheduler(context.Background(), cron.New(), nil)
+	runs := 0
+	job := blockingJob{fakeJob: fakeJob{handle: "a"}, runs: &runs, started: make(chan struct{}, 2), release: make(chan struct{})}
+	err := s.apply([]jobDefinition{{
+		key:     "a",
+		crontab: "1 * * * *",
+		timeout: time.Minute,
+		notify:  config.NotifyNever,
+		create:  func() (jobs.SyncJob, error) { return job, nil },
+	}})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.runAll()
+	}()
+	<-job.started
+
+	// e.g. the scheduled run colliding with the run at start
+	skipped := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		s.cron.Entry(s.entries["a"].id).WrappedJob.Run()
+		close(skipped)
+	}()
+	select {
+	case <-skipped:
+	case <-time.After(time.Second):
+		t.Error("second run wasn't skipped")
+	}
+	close(job.release)
+	wg.Wait()
+	assert.Equal(t, 1, runs)
+}
+
+// planFakeJob returns a fixed plan or error
+type planFakeJob struct {
+	fakeJob
+	plan *jobs.Plan
+	err  error
+}
+
+func (p planFakeJob) Plan(context.Context) (*jobs.Plan, error) { return p.plan, p.err }
+
+func TestPlanJobs(t *testing.T) {
+	ok := planFakeJob{fakeJob: fakeJob{handle: "a"}, plan: &jobs.Plan{Job: "job a", JobType: "PD Schedule", SlackHandle: "a", Add: []jobs.PlanUser{{ID: "W1", Name: "Egon Spengler"}}}}
+	failed := planFakeJob{fakeJob: fakeJob{handle: "b"}, err: errors.New("pagerduty: schedule not found")}
+
+	plans, exitCode := planJobs(context.Background(), []jobs.SyncJob{ok}, time.Minute)
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []*jobs.Plan{ok.plan}, plans)
+
+	plans, exitCode = planJobs(context.Background(), []jobs.SyncJob{ok, failed}, time.Minute)
+	assert.Equal(t, 1, exitCode, "a failed job fails the plan")
+	if assert.Len(t, plans, 2) {
+		assert.Equal(t, "pagerduty: schedule not found", plans[1].Error)
+	}
+
+	var text strings.Builder
+	assert.NoError(t, writePlans(&text, "text", plans))
+	assert.Contains(t, text.String(), "# slack group @b (PD Schedule)\n  ! pagerduty: schedule not found\n")
+	assert.Contains(t, text.String(), "Plan: 1 to add, 0 to remove in 1 of 2 group(s), 1 failed.\n")
+
+	var out strings.Builder
+	assert.NoError(t, writePlans(&out, "json", plans))
+	var decoded []jobs.Plan
+	if assert.NoError(t, json.Unmarshal([]byte(out.String()), &decoded)) && assert.Len(t, decoded, 2) {
+		assert.Equal(t, "job b", decoded[1].Job)
+		assert.Equal(t, "pagerduty: schedule not found", decoded[1].Error)
+	}
+}
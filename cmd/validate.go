@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/retry"
+	"github.com/sapcc/pagerduty2slack/internal/validate"
+)
+
+// validateCommand checks the config and prints a report, it returns the exit code
+func validateCommand(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	online := fs.Bool("online", false, "[true|false] also resolve all pagerduty objects and slack handles against the APIs")
+	output := fs.String("output", "text", "[text|json] format of the report")
+	_ = fs.Parse(args) // exits on error
+
+	// keep the report readable, client logs go to stderr anyway
+	initLogging("warn")
+
+	var report *validate.Report
+	cfg, err := config.ReadConfig(opts.ConfigFilePath)
+	if err != nil {
+		report = validate.FromError(opts.ConfigFilePath, err)
+	} else {
+		report = validateConfig(&cfg, *online)
+	}
+
+	switch *output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "validate: encoding report failed: %s\n", err.Error())
+			return 1
+		}
+	default:
+		fmt.Print(report.String())
+	}
+
+	if report.HasErrors() {
+		return 1
+	}
+	return 0
+}
+
+func validateConfig(cfg *config.Config, online bool) *validate.Report {
+	report := validate.Offline(cfg)
+	if !online {
+		return report
+	}
+
+	// credentials are only needed to talk to the APIs
+	cfgWithSecrets, err := config.NewConfig(cfg.ConfigFilePath)
+	if err != nil {
+		report.Findings = append(report.Findings, validate.Finding{Severity: validate.SeverityError, Field: "credentials", Message: err.Error()})
+		return report
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	slackClient, err := slackclient.NewClient(ctx, &cfgWithSecrets.Slack, retry.NewPolicy(cfgWithSecrets.Global))
+	if err != nil {
+		report.Findings = append(report.Findings, validate.Finding{Severity: validate.SeverityError, Field: "slack", Message: err.Error()})
+		return report
+	}
+	pdClient, err := pagerdutyclient.NewClient(ctx, &cfgWithSecrets.Pagerduty, retry.NewPolicy(cfgWithSecrets.Global))
+	if err != nil {
+		report.Findings = append(report.Findings, validate.Finding{Severity: validate.SeverityError, Field: "pagerduty", Message: err.Error()})
+		return report
+	}
+	log.Debug("validate: clients initialized, checking config against APIs")
+
+	validate.Online(ctx, report, cfg, pdClient, slackClient)
+	return report
+}
@@ -3,6 +3,8 @@ package pagerduty
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	pd "github.com/PagerDuty/go-pagerduty"
@@ -10,30 +12,36 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/retry"
 )
 
 type offsetInHours = time.Duration
 
 // Client wraps the pagerduty client.
 type Client struct {
-	cfg             *config.PagerdutyConfig
+	cfg             config.PagerdutyConfig
+	apiMutex        sync.RWMutex // guards the api client replaced on credential rotation
 	api             *pd.Client
 	apiUserInstance *pd.User
+	users           *userCache // users by ID, shared across jobs
+	retryPolicy     retry.Policy
 }
 
-// NewClient returns a new PagerdutyClient or an error.
-func NewClient(cfg *config.PagerdutyConfig) (*Client, error) {
-	pagerdutyClient := pd.NewClient(cfg.AuthToken)
+// NewClient returns a new PagerdutyClient retrying failed requests according to the policy or an error.
+func NewClient(ctx context.Context, cfg *config.PagerdutyConfig, retryPolicy retry.Policy) (*Client, error) {
+	pagerdutyClient := newAPIClient(cfg.AuthToken, cfg.APIEndpoint, retryPolicy)
 	if pagerdutyClient == nil {
 		return nil, fmt.Errorf("pagerduty: failed to initialize client")
 	}
 
 	c := &Client{
-		cfg: cfg,
-		api: pagerdutyClient,
+		cfg:         *cfg,
+		api:         pagerdutyClient,
+		users:       newUserCache(cfg.UserCacheTTL),
+		retryPolicy: retryPolicy,
 	}
 
-	defaultUser, err := c.findUserByEmail(cfg.APIUser)
+	defaultUser, err := c.findUserByEmail(ctx, cfg.APIUser)
 	if err != nil {
 		return nil, fmt.Errorf("pagerduty: getting default user by email '%s' failed: %w", cfg.APIUser, err)
 	}
@@ -42,14 +50,60 @@ func NewClient(cfg *config.PagerdutyConfig) (*Client, error) {
 	return c, nil
 }
 
+// newAPIClient returns a pagerduty api client retrying failed requests, using the endpoint if set
+func newAPIClient(token, endpoint string, retryPolicy retry.Policy) *pd.Client {
+	var options []pd.ClientOptions
+	if endpoint != "" {
+		options = append(options, pd.WithAPIEndpoint(endpoint))
+	}
+	c := pd.NewClient(token, options...)
+	if c != nil {
+		c.HTTPClient = retry.NewClient(c.HTTPClient, retryPolicy)
+	}
+	return c
+}
+
+// client returns the pagerduty api client
+func (c *Client) client() *pd.Client {
+	c.apiMutex.RLock()
+	defer c.apiMutex.RUnlock()
+	return c.api
+}
+
+// RefreshCredentials re-reads the token and api user and recreates the api client if they changed
+func (c *Client) RefreshCredentials(ctx context.Context) error {
+	c.apiMutex.Lock()
+	cfg := c.cfg
+	changed, err := cfg.LoadSecrets()
+	if err != nil || !changed {
+		c.apiMutex.Unlock()
+		if err != nil {
+			return fmt.Errorf("pagerduty: reading credentials failed: %w", err)
+		}
+		return nil
+	}
+	c.cfg, c.api = cfg, newAPIClient(cfg.AuthToken, cfg.APIEndpoint, c.retryPolicy)
+	c.apiMutex.Unlock()
+
+	apiUser, err := c.findUserByEmail(ctx, cfg.APIUser)
+	if err != nil {
+		return fmt.Errorf("pagerduty: getting default user by email '%s' with rotated credentials failed: %w", cfg.APIUser, err)
+	}
+	c.apiMutex.Lock()
+	c.apiUserInstance = apiUser
+	c.apiMutex.Unlock()
+	log.Info("pagerduty: credentials rotated")
+	return nil
+}
+
 // findUserByEmail returns the pagerduty user for the given email or an error.
-func (c *Client) findUserByEmail(email string) (*pd.User, error) {
-	userList, err := c.api.ListUsersWithContext(context.TODO(), pd.ListUsersOptions{Query: email})
+func (c *Client) findUserByEmail(ctx context.Context, email string) (*pd.User, error) {
+	users, err := c.listUsers(ctx, pd.ListUsersOptions{Query: email})
 	if err != nil {
 		return nil, err
 	}
-	// can this be more than 1 user?
-	for _, user := range userList.Users {
+	// the query also matches names and other emails containing it
+	for _, user := range users {
 		if user.Email == email {
 			return &user, nil
 		}
@@ -64,7 +118,8 @@ func (c *Client) WithoutPhone(users []pd.User) []pd.User {
 	for _, user := range users {
 		hasPhone := false
 		for _, c := range user.ContactMethods {
-			if c.Type == "phone_contact_method_reference" {
+			// references without and full contact methods with include[]=contact_methods
+			if c.Type == "phone_contact_method_reference" || c.Type == "phone_contact_method" {
 				hasPhone = true
 			}
 		}
@@ -76,15 +131,15 @@ func (c *Client) WithoutPhone(users []pd.User) []pd.User {
 }
 
 // ListOnCallUsers returns the OnCall users being on shift now
-func (c *Client) ListOnCallUsers(scheduleIDs []string, since, until offsetInHours, layerSyncStyle config.SyncStyle) ([]pd.User, []pd.APIObject, error) {
+func (c *Client) ListOnCallUsers(ctx context.Context, scheduleIDs []string, since, until offsetInHours, layerSyncStyle config.SyncStyle) ([]pd.User, []pd.APIObject, error) {
 	if layerSyncStyle == config.FinalLayer {
-		return c.listOnCallsFinalLayer(scheduleIDs, since, until)
+		return c.listOnCallsFinalLayer(ctx, scheduleIDs, since, until)
 	} else {
-		return c.listOnCallsLayers(scheduleIDs, since, until, layerSyncStyle)
+		return c.listOnCallsLayers(ctx, scheduleIDs, since, until, layerSyncStyle)
 	}
 }
 
-func (c *Client) listOnCallsFinalLayer(scheduleIDs []string, since, until offsetInHours) (users []pd.User, schedules []pd.APIObject, err error) {
+func (c *Client) listOnCallsFinalLayer(ctx context.Context, scheduleIDs []string, since, until offsetInHours) (users []pd.User, schedules []pd.APIObject, err error) {
 	onCallOpts := pd.ListOnCallOptions{
 		ScheduleIDs: scheduleIDs,
 		TimeZone:    "UTC",
@@ -92,19 +147,19 @@ func (c *Client) listOnCallsFinalLayer(scheduleIDs []string, since, until offset
 		Until:       util.TimestampToString(time.Now().UTC().Add(until)),
 		//Includes: []string{"users","schedules"}, // doesn't work - workaround sub request
 	}
-	resp, err := c.api.ListOnCallsWithContext(context.TODO(), onCallOpts)
+	onCalls, err := c.listOnCalls(ctx, onCallOpts)
 	if err != nil {
 		return nil, nil, err
 	}
-	users = c.listOnCallUsers(resp.OnCalls)
-	schedules, err = c.listOnCallSchedules(scheduleIDs, since, until)
+	users = c.listOnCallUsers(ctx, onCalls)
+	schedules, err = c.listOnCallSchedules(ctx, scheduleIDs, since, until)
 	if err != nil {
 		return nil, nil, err
 	}
 	return users, schedules, nil
 }
 
-func (c *Client) listOnCallsLayers(scheduleIDs []string, since, until offsetInHours, layerSyncStyle config.SyncStyle) (users []pd.User, schedules []pd.APIObject,
+func (c *Client) listOnCallsLayers(ctx context.Context, scheduleIDs []string, since, until offsetInHours, layerSyncStyle config.SyncStyle) (users []pd.User, schedules []pd.APIObject,
 	err error) {
 	// query options for schedule and override request (we needed since the api doesn't deliver the override info, beside api docu said it should)
 	scheduleOpts := pd.GetScheduleOptions{
@@ -120,34 +175,30 @@ func (c *Client) listOnCallsLayers(scheduleIDs []string, since, until offsetInHo
 	uniqueUsers := make(map[string]struct{})
 	// get schedule objects
 	for _, id := range scheduleIDs {
-		schedule, err := c.api.GetScheduleWithContext(context.TODO(), id, scheduleOpts)
+		schedule, err := c.client().GetScheduleWithContext(ctx, id, scheduleOpts)
 		if schedule == nil || err != nil {
 			return nil, schedules, err
 		}
 		schedules = append(schedules, schedule.APIObject)
 
 		// get overrides (since we can't trust the info in schedule object, we have to request separately until API is fixed
-		overrides, err := c.api.ListOverridesWithContext(context.TODO(), id, overrideOpts)
+		overrides, err := c.listOverrides(ctx, id, overrideOpts)
 		if err != nil {
 			return nil, nil, fmt.Errorf("pagerduty: failed listing overrides: %w", err)
 		}
-		if overrides != nil {
-			// add override layer if exist
-			if len(overrides.Overrides) > 0 {
-				for _, o := range overrides.Overrides {
-					if _, ok := uniqueUsers[o.User.ID]; !ok {
-						uniqueUsers[o.User.ID] = struct{}{}
-						users = append(users, c.getUser(o.User))
-					}
-				}
-				log.Debugf("pagerduty: handled overrides for schedule %s[%s]", schedule.Name, schedule.ID)
-				// if exist and we do not need the other layers - jump to next schedule
-				if layerSyncStyle == config.OverridesOnlyIfThere {
-					continue
+		// add override layer if exist
+		if len(overrides) > 0 {
+			for _, o := range overrides {
+				if _, ok := uniqueUsers[o.User.ID]; !ok {
+					uniqueUsers[o.User.ID] = struct{}{}
+					users = append(users, c.getUser(ctx, o.User))
 				}
 			}
-		} else {
-			log.Infof("pagerduty: no overrides for schedule %s[%s]", schedule.Name, schedule.ID)
+			log.Debugf("pagerduty: handled overrides for schedule %s[%s]", schedule.Name, schedule.ID)
+			// if exist and we do not need the other layers - jump to next schedule
+			if layerSyncStyle == config.OverridesOnlyIfThere {
+				continue
+			}
 		}
 
 		if len(schedule.ScheduleLayers) > 0 {
@@ -156,7 +207,7 @@ func (c *Client) listOnCallsLayers(scheduleIDs []string, since, until offsetInHo
 				for _, e := range l.RenderedScheduleEntries {
 					if _, ok := uniqueUsers[e.User.ID]; !ok {
 						uniqueUsers[e.User.ID] = struct{}{}
-						users = append(users, c.getUser(e.User))
+						users = append(users, c.getUser(ctx, e.User))
 					}
 				}
 			}
@@ -165,48 +216,152 @@ func (c *Client) listOnCallsLayers(scheduleIDs []string, since, until offsetInHo
 	return users, schedules, nil
 }
 
-// getUser
-func (c *Client) getUser(user pd.APIObject) pd.User {
-	o := pd.GetUserOptions{
-		Includes: []string{"contact_methods"},
-	}
-	u, err := c.api.GetUserWithContext(context.TODO(), user.ID, o)
+// getUser returns the user with contact methods or a user only containing the API object if it can't be retrieved
+func (c *Client) getUser(ctx context.Context, user pd.APIObject) pd.User {
+	u, err := c.cachedUser(ctx, user.ID)
 	if err != nil {
+		log.Infof("pagerduty: retrieving user '%s' failed", user.ID)
 		return pd.User{
 			APIObject: user,
 			Name:      user.Summary,
 		}
 	}
-	return *u
+	return u
 }
 
 // TeamMembers returns a pagerduty schedule for the given name or an error.
-func (c *Client) TeamMembers(teamIDs []string) ([]pd.User, []pd.APIObject, error) {
+func (c *Client) TeamMembers(ctx context.Context, teamIDs []string) ([]pd.User, []pd.APIObject, error) {
 	userListOpts := pd.ListUsersOptions{}
 	userListOpts.Includes = []string{"contact_methods", "notification_rules"}
 	userListOpts.TeamIDs = teamIDs
 
-	response, err := c.api.ListUsersWithContext(context.TODO(), userListOpts)
-
+	users, err := c.listUsers(ctx, userListOpts)
 	if err != nil {
 		return nil, nil, err
 	}
+	c.users.set(users...)
 
 	teamObjects := []pd.APIObject{}
 	for _, id := range teamIDs {
-		response, err := c.api.GetTeamWithContext(context.TODO(), id)
+		response, err := c.client().GetTeamWithContext(ctx, id)
 		if err != nil {
 			return nil, nil, fmt.Errorf("pagerduty: team not found: %w", err)
 		}
 		teamObjects = append(teamObjects, response.APIObject)
 	}
-	return response.Users, teamObjects, nil
+	return users, teamObjects, nil
 }
 
-// listOnCallUsers returns unique PagerDuty users for a list of OnCalls
-func (c *Client) listOnCallUsers(onCalls []pd.OnCall) (users []pd.User) {
-	opts := pd.GetUserOptions{Includes: []string{"contact_methods"}}
+// TeamManagers returns the users with the manager role in the teams
+func (c *Client) TeamManagers(ctx context.Context, teamIDs []string) ([]pd.User, error) {
+	var managers []pd.User
+	seen := make(map[string]struct{})
+	for _, id := range teamIDs {
+		members, err := c.listTeamMembers(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty: listing members of team '%s' failed: %w", id, err)
+		}
+		for _, m := range members {
+			if _, ok := seen[m.User.ID]; ok || m.Role != "manager" {
+				continue
+			}
+			seen[m.User.ID] = struct{}{}
+			managers = append(managers, c.getUser(ctx, m.User))
+		}
+	}
+	return managers, nil
+}
+
+// GetSchedule returns the pagerduty schedule for the given ID or an error.
+func (c *Client) GetSchedule(ctx context.Context, id string) (*pd.Schedule, error) {
+	schedule, err := c.client().GetScheduleWithContext(ctx, id, pd.GetScheduleOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty: schedule '%s' not found: %w", id, err)
+	}
+	return schedule, nil
+}
+
+// GetTeam returns the pagerduty team for the given ID or an error.
+func (c *Client) GetTeam(ctx context.Context, id string) (*pd.Team, error) {
+	team, err := c.client().GetTeamWithContext(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty: team '%s' not found: %w", id, err)
+	}
+	return team, nil
+}
+
+// GetEscalationPolicy returns the pagerduty escalation policy for the given ID or an error.
+func (c *Client) GetEscalationPolicy(ctx context.Context, id string) (*pd.EscalationPolicy, error) {
+	policy, err := c.client().GetEscalationPolicyWithContext(ctx, id, &pd.GetEscalationPolicyOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty: escalation policy '%s' not found: %w", id, err)
+	}
+	return policy, nil
+}
+
+// OnCallUser is a pagerduty user currently on call for an escalation policy
+type OnCallUser struct {
+	User             pd.User
+	EscalationLevels []uint // levels the user is on call at, ascending
+}
+
+// ListEscalationPolicyOnCalls returns the users currently on call at the given levels of the escalation policies.
+// All levels are returned if levels is empty. The api can't filter by level, so it's done here.
+func (c *Client) ListEscalationPolicyOnCalls(ctx context.Context, policyIDs []string, levels []uint) ([]OnCallUser, []pd.APIObject, error) {
+	onCallOpts := pd.ListOnCallOptions{
+		EscalationPolicyIDs: policyIDs,
+		TimeZone:            "UTC",
+	}
+	allOnCalls, err := c.listOnCalls(ctx, onCallOpts)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	wanted := make(map[uint]struct{}, len(levels))
+	for _, l := range levels {
+		wanted[l] = struct{}{}
+	}
+	var onCalls []pd.OnCall
+	userLevels := make(map[string][]uint)
+	for _, o := range allOnCalls {
+		if _, ok := wanted[o.EscalationLevel]; len(wanted) > 0 && !ok {
+			continue
+		}
+		onCalls = append(onCalls, o)
+		if !containsLevel(userLevels[o.User.ID], o.EscalationLevel) {
+			userLevels[o.User.ID] = append(userLevels[o.User.ID], o.EscalationLevel)
+		}
+	}
+
+	var users []OnCallUser
+	for _, u := range c.listOnCallUsers(ctx, onCalls) {
+		l := userLevels[u.ID]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		users = append(users, OnCallUser{User: u, EscalationLevels: l})
+	}
+
+	var policies []pd.APIObject
+	for _, id := range policyIDs {
+		policy, err := c.GetEscalationPolicy(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		policies = append(policies, policy.APIObject)
+	}
+	return users, policies, nil
+}
+
+func containsLevel(levels []uint, level uint) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// listOnCallUsers returns unique PagerDuty users for a list of OnCalls
+func (c *Client) listOnCallUsers(ctx context.Context, onCalls []pd.OnCall) (users []pd.User) {
 	distinctUsers := make(map[string]struct{})
 	for _, u := range onCalls {
 		if _, ok := distinctUsers[u.User.ID]; ok {
@@ -215,22 +370,13 @@ func (c *Client) listOnCallUsers(onCalls []pd.OnCall) (users []pd.User) {
 			continue
 		}
 		distinctUsers[u.User.ID] = struct{}{}
-
-		user, err := c.api.GetUserWithContext(context.TODO(), u.User.ID, opts)
-		if err != nil {
-			log.Infof("pagerduty: retrieving user '%s' failed", u.User.ID)
-			users = append(users, pd.User{
-				APIObject: u.User.APIObject,
-				Name:      u.User.Summary})
-			continue
-		}
-		users = append(users, *user)
+		users = append(users, c.getUser(ctx, u.User.APIObject))
 	}
 	return users
 }
 
 // listOnCallSchedules returns actual pagerDuty schedule API objects for a list of schedule IDs
-func (c *Client) listOnCallSchedules(ids []string, since, until offsetInHours) (schedules []pd.APIObject, err error) {
+func (c *Client) listOnCallSchedules(ctx context.Context, ids []string, since, until offsetInHours) (schedules []pd.APIObject, err error) {
 	// query options for schedule and override request (we needed since the api doesn't deliver the override info, beside api docu said it should)
 	scheduleOpts := pd.GetScheduleOptions{
 		TimeZone: "UTC",
@@ -238,7 +384,7 @@ func (c *Client) listOnCallSchedules(ids []string, since, until offsetInHours) (
 		Until:    util.TimestampToString(time.Now().UTC().Add(until)),
 	}
 	for _, id := range ids {
-		schedule, err := c.api.GetScheduleWithContext(context.TODO(), id, scheduleOpts)
+		schedule, err := c.client().GetScheduleWithContext(ctx, id, scheduleOpts)
 		if err != nil {
 			return nil, err
 		}
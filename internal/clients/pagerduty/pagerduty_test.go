@@ -2,10 +2,13 @@ package pagerduty
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -20,11 +23,15 @@ type expectation struct {
 	path     string
 	response *http.Response
 	query    string
+	pages    []*http.Response // returned in order instead of response
+	offsets  []string         // offsets requested for the pages
+	cursors  []string         // cursors requested for the pages
 }
 
 type pagerDutyMock struct {
 	t            *testing.T
 	expectations map[string]*expectation
+	requested    []string // urls of the requests
 	DoMock       func(req *http.Request) (*http.Response, error)
 }
 
@@ -36,7 +43,7 @@ func TestGetUserByEmail(t *testing.T) {
 	client, mock := setupPagerDuty(t)
 
 	mock.expectWithQuery("/users", "admin@test.com", usersResponse(user("admin", "0001", true, true)))
-	actual, err := client.findUserByEmail("admin@test.com")
+	actual, err := client.findUserByEmail(context.Background(), "admin@test.com")
 
 	assert.NoError(t, err)
 	if assert.NotNil(t, actual) {
@@ -118,7 +125,7 @@ func TestGetUser(t *testing.T) {
 	for _, test := range testCases {
 		mock.expect("/users/"+test.expectedID, userResponse(user(test.expectedName, test.expectedID, true, true)))
 
-		actual := client.getUser(test.apiObject)
+		actual := client.getUser(context.Background(), test.apiObject)
 		assert.Equal(t, test.expectedID, actual.ID)
 		assert.Equal(t, test.expectedName, actual.Name)
 	}
@@ -136,7 +143,7 @@ func TestGetPDTeamMembers(t *testing.T) {
 	mock.expect("/teams/team_admin", teamResult(team("Team Admin", "team_admin")))
 	mock.expect("/teams/team_support", teamResult(team("Team Support", "team_support")))
 
-	users, apiObjects, err := client.TeamMembers(teamIDs)
+	users, apiObjects, err := client.TeamMembers(context.Background(), teamIDs)
 
 	assert.NoError(t, err)
 	assert.Equal(t, 3, len(users))
@@ -149,13 +156,28 @@ func TestGetPDTeamMembersError(t *testing.T) {
 
 	mock.expect("/users", apiNotFoundError())
 
-	users, apiObjects, err := client.TeamMembers(teamIDs)
+	users, apiObjects, err := client.TeamMembers(context.Background(), teamIDs)
 
 	assert.Error(t, err)
 	assert.Nil(t, users)
 	assert.Nil(t, apiObjects)
 }
 
+func TestTeamManagers(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+	mock.expect("/teams/team_support/members", createResponse(http.StatusOK, pagerduty.ListTeamMembersResponse{Members: []pagerduty.Member{
+		{User: pagerduty.APIObject{ID: "0001"}, Role: "manager"},
+		{User: pagerduty.APIObject{ID: "0002"}, Role: "responder"},
+	}}))
+	mock.expect("/users/0001", userResponse(user("manager", "0001", true, true)))
+
+	managers, err := client.TeamManagers(context.Background(), []string{"team_support"})
+
+	if assert.NoError(t, err) && assert.Len(t, managers, 1) {
+		assert.Equal(t, "manager", managers[0].Name)
+	}
+}
+
 func TestListOnCallFinal(t *testing.T) {
 	client, mock := setupPagerDuty(t)
 	scheduleIDs := []string{"1000", "2000"}
@@ -173,7 +195,7 @@ func TestListOnCallFinal(t *testing.T) {
 	mock.expect("/schedules/1000", scheduleResponse(schedule("Weekly OnCallRotation", "1000")))
 	mock.expect("/schedules/2000", scheduleResponse(schedule("Daily OnCallRotation", "2000")))
 
-	users, schedules, err := client.listOnCallsFinalLayer(scheduleIDs, since, until)
+	users, schedules, err := client.listOnCallsFinalLayer(context.Background(), scheduleIDs, since, until)
 
 	assert.NoError(t, err)
 	assert.Equal(t, 3, len(users))
@@ -198,19 +220,282 @@ func TestListOnCallUseAllActiveLayers(t *testing.T) {
 	mock.expect("/schedules/4001", scheduleResponse(scheduleWithLayer("Schedule With Layers", "4001", user("user02", "0002", true, true))))
 	mock.expect("/schedules/4001/overrides", noOverridesResponse())
 
-	users, schedules, err := client.listOnCallsLayers(scheduleIDs, since, until, config.AllActiveLayers)
+	users, schedules, err := client.listOnCallsLayers(context.Background(), scheduleIDs, since, until, config.AllActiveLayers)
 
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(users))
 	assert.Equal(t, 2, len(schedules))
 }
 
+func TestListEscalationPolicyOnCalls(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+
+	mock.expect("/users/0001", userResponse(user("user01", "0001", true, true)))
+	mock.expect("/users/0002", userResponse(user("user02", "0002", true, true)))
+	mock.expect("/users/0003", userResponse(user("user03", "0003", true, true)))
+	mock.expect("/oncalls", onCallsResult(
+		levelOnCall(policy("Support", "500"), user("user01", "0001", true, true), 1),
+		levelOnCall(policy("Support", "500"), user("user01", "0001", true, true), 3),
+		levelOnCall(policy("Support", "500"), user("user02", "0002", true, true), 2),
+		levelOnCall(policy("Support", "500"), user("user03", "0003", true, true), 3),
+	))
+	mock.expect("/escalation_policies/500", policyResponse(policy("Support", "500")))
+
+	users, policies, err := client.ListEscalationPolicyOnCalls(context.Background(), []string{"500"}, []uint{1, 3})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(policies))
+	if assert.Equal(t, 2, len(users)) {
+		assert.Equal(t, "0001", users[0].User.ID)
+		assert.Equal(t, []uint{1, 3}, users[0].EscalationLevels)
+		assert.Equal(t, "0003", users[1].User.ID)
+		assert.Equal(t, []uint{3}, users[1].EscalationLevels)
+	}
+
+}
+
+func TestListEscalationPolicyOnCallsAllLevels(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+
+	mock.expect("/users/0001", userResponse(user("user01", "0001", true, true)))
+	mock.expect("/users/0002", userResponse(user("user02", "0002", true, true)))
+	mock.expect("/oncalls", onCallsResult(
+		levelOnCall(policy("Support", "500"), user("user01", "0001", true, true), 1),
+		levelOnCall(policy("Support", "500"), user("user02", "0002", true, true), 2),
+	))
+	mock.expect("/escalation_policies/500", policyResponse(policy("Support", "500")))
+
+	users, _, err := client.ListEscalationPolicyOnCalls(context.Background(), []string{"500"}, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(users))
+}
+
+func TestTeamMembersPaginated(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+
+	users := mock.expectPages("/users",
+		usersPage(0, true, userWithTeam("user01", "0001", "team", true, true), userWithTeam("user02", "0002", "team", true, true)),
+		usersPage(2, true, userWithTeam("user03", "0003", "team", true, true), userWithTeam("user04", "0004", "team", true, true)),
+		usersPage(4, false, userWithTeam("user05", "0005", "team", true, true)),
+	)
+	mock.expect("/teams/team", teamResult(team("Team", "team")))
+
+	members, _, err := client.TeamMembers(context.Background(), []string{"team"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(members))
+	assert.Equal(t, []string{"", "2", "4"}, users.offsets)
+}
+
+func TestFindUserByEmailPaginated(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+
+	mock.expectPages("/users",
+		usersPage(0, true, user("admin.two", "0001", true, true), user("admin.three", "0002", true, true)),
+		usersPage(2, false, pagerduty.User{APIObject: pagerduty.APIObject{ID: "0003"}, Email: "admin@test.com"}),
+	)
+
+	actual, err := client.findUserByEmail(context.Background(), "admin@test.com")
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, actual) {
+		assert.Equal(t, "0003", actual.ID)
+	}
+}
+
+func TestListOnCallsPaginated(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+
+	mock.expect("/users/0001", userResponse(user("user01", "0001", true, true)))
+	mock.expect("/users/0002", userResponse(user("user02", "0002", true, true)))
+	mock.expect("/users/0003", userResponse(user("user03", "0003", true, true)))
+	onCalls := mock.expectPages("/oncalls",
+		onCallsPage(0, true,
+			onCall(schedule("Weekly", "1000"), policy("Admin", "100"), user("user01", "0001", true, true)),
+			onCall(schedule("Weekly", "1000"), policy("Admin", "100"), user("user02", "0002", true, true))),
+		onCallsPage(2, false,
+			onCall(schedule("Weekly", "1000"), policy("Admin", "100"), user("user03", "0003", true, true))),
+	)
+	mock.expect("/schedules/1000", scheduleResponse(schedule("Weekly", "1000")))
+
+	users, _, err := client.listOnCallsFinalLayer(context.Background(), []string{"1000"}, 0, 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(users))
+	assert.Equal(t, []string{"", "2"}, onCalls.offsets)
+}
+
+func TestListOverridesPaginated(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+	client.cfg.APIEndpoint = "https://pagerduty.example.com/"
+
+	overrides := mock.expectPages("/schedules/1000/overrides",
+		overridesPage(0, true, override("0001"), override("0002")),
+		overridesPage(2, false, override("0003")),
+	)
+
+	actual, err := client.listOverrides(context.Background(), "1000", pagerduty.ListOverridesOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(actual))
+	assert.Equal(t, []string{"0", "2"}, overrides.offsets)
+	assert.Len(t, mock.requested, 2)
+	for _, u := range mock.requested {
+		assert.True(t, strings.HasPrefix(u, "https://pagerduty.example.com/schedules/1000/overrides?"), u)
+	}
+}
+
+func TestListOverridesError(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+
+	mock.expect("/schedules/1000/overrides", apiNotFoundError())
+
+	_, err := client.listOverrides(context.Background(), "1000", pagerduty.ListOverridesOptions{})
+
+	var apiErr pagerduty.APIError
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	}
+}
+
+func TestListSchedulesPaginated(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+
+	schedules := mock.expectPages("/schedules",
+		schedulesPage(0, true, schedule("Weekly", "1000"), schedule("Daily", "1001")),
+		schedulesPage(2, false, schedule("Backup", "1002")),
+	)
+
+	actual, err := client.listSchedules(context.Background(), pagerduty.ListSchedulesOptions{})
+
+	assert.NoError(t, err)
+	if assert.Equal(t, 3, len(actual)) {
+		assert.Equal(t, "1002", actual[2].ID)
+	}
+	assert.Equal(t, []string{"", "2"}, schedules.offsets)
+}
+
+// auditRecordsPage is a page of a cursor based list response
+type auditRecordsPage struct {
+	cursorPage
+	Records []pagerduty.AuditRecord `json:"records"`
+}
+
+func TestPaginateCursor(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+
+	records := mock.expectPages("/audit/records",
+		createResponse(http.StatusOK, auditRecordsPage{cursorPage: cursorPage{Limit: 2, NextCursor: "c2"}, Records: []pagerduty.AuditRecord{{ID: "R1"}, {ID: "R2"}}}),
+		createResponse(http.StatusOK, auditRecordsPage{cursorPage: cursorPage{Limit: 2, NextCursor: "c3"}, Records: []pagerduty.AuditRecord{{ID: "R3"}, {ID: "R4"}}}),
+		createResponse(http.StatusOK, auditRecordsPage{cursorPage: cursorPage{Limit: 2}, Records: []pagerduty.AuditRecord{{ID: "R5"}}}),
+	)
+
+	var ids []string
+	err := paginateCursor(func(cursor string) (cursorPage, error) {
+		q := url.Values{}
+		q.Set("limit", strconv.Itoa(pageLimit))
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		var page auditRecordsPage
+		if err := client.getJSON(context.Background(), "/audit/records", q, &page); err != nil {
+			return cursorPage{}, err
+		}
+		for _, r := range page.Records {
+			ids = append(ids, r.ID)
+		}
+		return page.cursorPage, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"R1", "R2", "R3", "R4", "R5"}, ids)
+	assert.Equal(t, []string{"", "c2", "c3"}, records.cursors)
+}
+
+func TestPaginateCursorNotAdvancing(t *testing.T) {
+	var cursors []string
+	// the api ignores the cursor and returns the same page again
+	err := paginateCursor(func(cursor string) (cursorPage, error) {
+		cursors = append(cursors, cursor)
+		return cursorPage{Limit: 100, NextCursor: "c2"}, nil
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"", "c2"}, cursors)
+}
+
+func TestPaginateWithoutLimit(t *testing.T) {
+	err := paginate(func(_ uint) (pagerduty.APIListObject, error) {
+		return pagerduty.APIListObject{More: true}, nil
+	})
+
+	assert.Error(t, err)
+}
+
+func TestPaginateOffsetNotAdvancing(t *testing.T) {
+	var offsets []uint
+	// the api ignores the offset and returns the first page again
+	err := paginate(func(offset uint) (pagerduty.APIListObject, error) {
+		offsets = append(offsets, offset)
+		return pagerduty.APIListObject{More: true, Limit: 100}, nil
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, []uint{0, 100}, offsets)
+}
+
+func TestUserCache(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+	now := time.Now()
+	client.users.now = func() time.Time { return now }
+
+	requests := mock.expectPages("/users/0001",
+		userResponse(user("user01", "0001", true, true)),
+		userResponse(user("user01", "0001", true, false)),
+	)
+
+	first := client.getUser(context.Background(), pagerduty.APIObject{ID: "0001"})
+	cached := client.getUser(context.Background(), pagerduty.APIObject{ID: "0001"})
+	assert.Equal(t, first, cached)
+	assert.Equal(t, 1, len(requests.offsets))
+
+	now = now.Add(2 * time.Hour)
+	expired := client.getUser(context.Background(), pagerduty.APIObject{ID: "0001"})
+	assert.Equal(t, 2, len(requests.offsets))
+	assert.Equal(t, 1, len(client.WithoutPhone([]pagerduty.User{expired})))
+}
+
+func TestWarmUpUserCache(t *testing.T) {
+	client, mock := setupPagerDuty(t)
+
+	mock.expectPages("/users",
+		usersPage(0, true, user("user01", "0001", true, true), user("user02", "0002", true, true)),
+		usersPage(2, false, user("user03", "0003", true, true)),
+	)
+	mock.expect("/oncalls", onCallsResult(
+		onCall(schedule("Weekly", "1000"), policy("Admin", "100"), user("user01", "0001", true, true)),
+		onCall(schedule("Weekly", "1000"), policy("Admin", "100"), user("user03", "0003", true, true)),
+	))
+	mock.expect("/schedules/1000", scheduleResponse(schedule("Weekly", "1000")))
+
+	assert.NoError(t, client.WarmUpUserCache(context.Background()))
+	// no expectations for /users/ID, the mock fails the test on requests
+	users, _, err := client.listOnCallsFinalLayer(context.Background(), []string{"1000"}, 0, 0)
+
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(users)) {
+		assert.Equal(t, "user01", users[0].Name)
+		assert.Equal(t, "user03", users[1].Name)
+	}
+}
+
 func setupPagerDuty(t *testing.T) (client *Client, mock *pagerDutyMock) {
 	cfg := config.PagerdutyConfig{AuthToken: "test", APIUser: "test@company.com"}
 	c := pagerduty.NewClient("")
 	mock = &pagerDutyMock{t: t, expectations: make(map[string]*expectation)}
 	c.HTTPClient = mock
-	return &Client{cfg: &cfg, api: c}, mock
+	return &Client{cfg: cfg, api: c, users: newUserCache(time.Hour)}, mock
 }
 
 func (m *pagerDutyMock) expect(path string, response *http.Response) {
@@ -225,11 +510,28 @@ func (m *pagerDutyMock) expectWithQuery(path, query string, response *http.Respo
 	m.expectations[path] = e
 }
 
+func (m *pagerDutyMock) expectPages(path string, pages ...*http.Response) *expectation {
+	m.expect(path, nil)
+	m.expectations[path].pages = pages
+	return m.expectations[path]
+}
+
 func (m *pagerDutyMock) doMock(req *http.Request) (resp *http.Response, err error) {
+	m.requested = append(m.requested, req.URL.String())
 	pathParts := strings.Split(req.URL.Path, "/")
 	path := strings.Join(pathParts, "/")
 	if exp, ok := m.expectations[path]; ok {
-		return exp.response, nil
+		if exp.pages == nil {
+			return exp.response, nil
+		}
+		exp.offsets = append(exp.offsets, req.URL.Query().Get("offset"))
+		exp.cursors = append(exp.cursors, req.URL.Query().Get("cursor"))
+		if len(exp.pages) == 0 {
+			m.t.Fatalf("no page left for '%s'", path)
+		}
+		resp = exp.pages[0]
+		exp.pages = exp.pages[1:]
+		return resp, nil
 	}
 	m.t.Fatalf("expectation for '%s' is missing", path)
 	return createResponse(http.StatusNotImplemented, nil), nil
@@ -298,6 +600,34 @@ func team(name, id string) pagerduty.Team {
 	return pagerduty.Team{Name: name, APIObject: pagerduty.APIObject{ID: id}}
 }
 
+func usersPage(offset uint, more bool, users ...pagerduty.User) *http.Response {
+	return createResponse(http.StatusOK, pagerduty.ListUsersResponse{
+		APIListObject: pagerduty.APIListObject{Limit: 2, Offset: offset, More: more},
+		Users:         users,
+	})
+}
+
+func schedulesPage(offset uint, more bool, schedules ...pagerduty.Schedule) *http.Response {
+	return createResponse(http.StatusOK, pagerduty.ListSchedulesResponse{
+		APIListObject: pagerduty.APIListObject{Limit: 2, Offset: offset, More: more},
+		Schedules:     schedules,
+	})
+}
+
+func onCallsPage(offset uint, more bool, oncalls ...pagerduty.OnCall) *http.Response {
+	return createResponse(http.StatusOK, pagerduty.ListOnCallsResponse{
+		APIListObject: pagerduty.APIListObject{Limit: 2, Offset: offset, More: more},
+		OnCalls:       oncalls,
+	})
+}
+
+func overridesPage(offset uint, more bool, overrides ...pagerduty.Override) *http.Response {
+	return createResponse(http.StatusOK, listOverridesResponse{
+		APIListObject: pagerduty.APIListObject{Limit: 2, Offset: offset, More: more},
+		Overrides:     overrides,
+	})
+}
+
 func onCallsResult(oncalls ...pagerduty.OnCall) *http.Response {
 	return createResponse(http.StatusOK, pagerduty.ListOnCallsResponse{
 		OnCalls: oncalls,
@@ -308,6 +638,16 @@ func onCall(schedule pagerduty.Schedule, policy pagerduty.EscalationPolicy, user
 	return pagerduty.OnCall{Schedule: schedule, EscalationPolicy: policy, User: user}
 }
 
+func levelOnCall(policy pagerduty.EscalationPolicy, user pagerduty.User, level uint) pagerduty.OnCall {
+	return pagerduty.OnCall{EscalationPolicy: policy, User: user, EscalationLevel: level}
+}
+
+func policyResponse(policy pagerduty.EscalationPolicy) *http.Response {
+	return createResponse(http.StatusOK, map[string]pagerduty.EscalationPolicy{
+		"escalation_policy": policy,
+	})
+}
+
 func scheduleResponse(schedule pagerduty.Schedule) *http.Response {
 	return createResponse(http.StatusOK, map[string]pagerduty.Schedule{
 		"schedule": schedule,
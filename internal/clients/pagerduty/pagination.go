@@ -0,0 +1,210 @@
+package pagerduty
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	pd "github.com/PagerDuty/go-pagerduty"
+)
+
+const (
+	// pageLimit is the maximum page size accepted by the pagerduty api
+	pageLimit = 100
+	// defaultAPIEndpoint of the pagerduty rest api if none is configured
+	defaultAPIEndpoint = "https://api.pagerduty.com"
+)
+
+// paginate calls fetch for every page of a list request until the api reports no more results.
+// fetch requests the page at the given offset and returns the pagination info of the response.
+func paginate(fetch func(offset uint) (pd.APIListObject, error)) error {
+	var offset uint
+	for {
+		page, err := fetch(offset)
+		if err != nil {
+			return err
+		}
+		if !page.More {
+			return nil
+		}
+		if page.Limit == 0 {
+			return fmt.Errorf("pagerduty: more results announced without page limit at offset %d", page.Offset)
+		}
+		next := page.Offset + page.Limit
+		if next <= offset {
+			return fmt.Errorf("pagerduty: more results announced but the offset doesn't advance beyond %d", offset)
+		}
+		offset = next
+	}
+}
+
+// endpoint returns the url of the pagerduty rest api without trailing slash
+func (c *Client) endpoint() string {
+	c.apiMutex.RLock()
+	defer c.apiMutex.RUnlock()
+	if c.cfg.APIEndpoint == "" {
+		return defaultAPIEndpoint
+	}
+	return strings.TrimRight(c.cfg.APIEndpoint, "/")
+}
+
+// listUsers returns the users of all pages matching the options
+func (c *Client) listUsers(ctx context.Context, opts pd.ListUsersOptions) ([]pd.User, error) {
+	var users []pd.User
+	opts.Limit = pageLimit
+	err := paginate(func(offset uint) (pd.APIListObject, error) {
+		opts.Offset = offset
+		resp, err := c.client().ListUsersWithContext(ctx, opts)
+		if err != nil {
+			return pd.APIListObject{}, err
+		}
+		users = append(users, resp.Users...)
+		return resp.APIListObject, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// listTeamMembers returns the members of all pages of the team
+func (c *Client) listTeamMembers(ctx context.Context, teamID string) ([]pd.Member, error) {
+	var members []pd.Member
+	opts := pd.ListTeamMembersOptions{Limit: pageLimit}
+	err := paginate(func(offset uint) (pd.APIListObject, error) {
+		opts.Offset = offset
+		resp, err := c.client().ListTeamMembers(ctx, teamID, opts)
+		if err != nil {
+			return pd.APIListObject{}, err
+		}
+		members = append(members, resp.Members...)
+		return resp.APIListObject, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// listOnCalls returns the on-calls of all pages matching the options
+func (c *Client) listOnCalls(ctx context.Context, opts pd.ListOnCallOptions) ([]pd.OnCall, error) {
+	var onCalls []pd.OnCall
+	opts.Limit = pageLimit
+	err := paginate(func(offset uint) (pd.APIListObject, error) {
+		opts.Offset = offset
+		resp, err := c.client().ListOnCallsWithContext(ctx, opts)
+		if err != nil {
+			return pd.APIListObject{}, err
+		}
+		onCalls = append(onCalls, resp.OnCalls...)
+		return resp.APIListObject, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return onCalls, nil
+}
+
+// listSchedules returns the schedules of all pages matching the options
+func (c *Client) listSchedules(ctx context.Context, opts pd.ListSchedulesOptions) ([]pd.Schedule, error) {
+	var schedules []pd.Schedule
+	opts.Limit = pageLimit
+	err := paginate(func(offset uint) (pd.APIListObject, error) {
+		opts.Offset = offset
+		resp, err := c.client().ListSchedulesWithContext(ctx, opts)
+		if err != nil {
+			return pd.APIListObject{}, err
+		}
+		schedules = append(schedules, resp.Schedules...)
+		return resp.APIListObject, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// listOverridesResponse is a page of the overrides of a schedule
+type listOverridesResponse struct {
+	pd.APIListObject
+	Overrides []pd.Override `json:"overrides"`
+}
+
+// listOverrides returns the overrides of all pages of the schedule. ListOverridesWithContext
+// neither sends nor returns pagination parameters, so the request is built here.
+func (c *Client) listOverrides(ctx context.Context, scheduleID string, opts pd.ListOverridesOptions) ([]pd.Override, error) {
+	var overrides []pd.Override
+	err := paginate(func(offset uint) (pd.APIListObject, error) {
+		q := url.Values{}
+		q.Set("since", opts.Since)
+		q.Set("until", opts.Until)
+		q.Set("limit", strconv.Itoa(pageLimit))
+		q.Set("offset", strconv.FormatUint(uint64(offset), 10))
+
+		var page listOverridesResponse
+		if err := c.getJSON(ctx, fmt.Sprintf("/schedules/%s/overrides", url.PathEscape(scheduleID)), q, &page); err != nil {
+			return pd.APIListObject{}, err
+		}
+		overrides = append(overrides, page.Overrides...)
+		return page.APIListObject, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// cursorPage is the pagination info of a list response of the cursor based endpoints, e.g. the audit records
+type cursorPage struct {
+	Limit      uint   `json:"limit"`
+	NextCursor string `json:"next_cursor"` // empty on the last page
+}
+
+// paginateCursor calls fetch for every page of a cursor based list request until the api returns no next cursor.
+// fetch requests the page at the given cursor, empty for the first page, and returns the pagination info of the response.
+func paginateCursor(fetch func(cursor string) (cursorPage, error)) error {
+	var cursor string
+	for {
+		page, err := fetch(cursor)
+		if err != nil {
+			return err
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		if page.NextCursor == cursor {
+			return fmt.Errorf("pagerduty: more results announced but the cursor doesn't advance beyond '%s'", cursor)
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// getJSON requests the path of the rest api with the query and decodes the JSON response into v,
+// for requests the library doesn't paginate
+func (c *Client) getJSON(ctx context.Context, path string, q url.Values, v any) error {
+	u := fmt.Sprintf("%s%s?%s", c.endpoint(), path, q.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client().Do(req, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := pd.APIError{}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr) // status code is reported without error object
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("pagerduty: decoding response of %s failed: %w", path, err)
+	}
+	return nil
+}
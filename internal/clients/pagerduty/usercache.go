@@ -0,0 +1,98 @@
+package pagerduty
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	pd "github.com/PagerDuty/go-pagerduty"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sapcc/pagerduty2slack/internal/metrics"
+)
+
+// userIncludes are the details requested for every user
+var userIncludes = []string{"contact_methods"}
+
+// userCache holds pagerduty users including their contact methods by ID, shared across jobs
+type userCache struct {
+	mutex sync.Mutex
+	ttl   time.Duration
+	users map[string]cachedUser
+	now   func() time.Time
+}
+
+type cachedUser struct {
+	user    pd.User
+	expires time.Time
+}
+
+func newUserCache(ttl time.Duration) *userCache {
+	return &userCache{
+		ttl:   ttl,
+		users: make(map[string]cachedUser),
+		now:   time.Now,
+	}
+}
+
+// get returns the user if cached and not expired
+func (uc *userCache) get(id string) (pd.User, bool) {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+	cached, ok := uc.users[id]
+	if !ok || uc.now().After(cached.expires) {
+		return pd.User{}, false
+	}
+	return cached.user, true
+}
+
+// set caches the users until the ttl expired
+func (uc *userCache) set(users ...pd.User) {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+	expires := uc.now().Add(uc.ttl)
+	for _, u := range users {
+		uc.users[u.ID] = cachedUser{user: u, expires: expires}
+	}
+}
+
+// purge removes expired users
+func (uc *userCache) purge() {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+	now := uc.now()
+	for id, cached := range uc.users {
+		if now.After(cached.expires) {
+			delete(uc.users, id)
+		}
+	}
+}
+
+// WarmUpUserCache loads all pagerduty users into the user cache, so jobs don't need to request them one by one
+func (c *Client) WarmUpUserCache(ctx context.Context) error {
+	users, err := c.listUsers(ctx, pd.ListUsersOptions{Includes: userIncludes})
+	if err != nil {
+		return fmt.Errorf("pagerduty: warming up user cache failed: %w", err)
+	}
+	c.users.purge()
+	c.users.set(users...)
+	log.Infof("pagerduty: cached %d users", len(users))
+	return nil
+}
+
+// cachedUser returns the user with contact methods from the cache or requests it
+func (c *Client) cachedUser(ctx context.Context, id string) (pd.User, error) {
+	if u, ok := c.users.get(id); ok {
+		metrics.ObserveUserCacheLookup(true)
+		return u, nil
+	}
+	metrics.ObserveUserCacheLookup(false)
+
+	u, err := c.client().GetUserWithContext(ctx, id, pd.GetUserOptions{Includes: userIncludes})
+	if err != nil {
+		return pd.User{}, err
+	}
+	c.users.set(*u)
+	return *u, nil
+}
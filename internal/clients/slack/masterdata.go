@@ -0,0 +1,126 @@
+package slack
+
+import (
+	"strings"
+
+	slackgo "github.com/slack-go/slack"
+)
+
+// masterData is a snapshot of the slack workspace. It's never modified but replaced as a whole,
+// so readers don't need locks.
+type masterData struct {
+	channelsByID   map[string]slackgo.Channel   // channels the bot can post to by ID
+	channelsByName map[string]slackgo.Channel   // channels by lowercase name
+	usersByEmail   map[string]slackgo.User      // active users by lowercase email
+	usersByID      map[string]slackgo.User      // all users by ID
+	groupsByHandle map[string]slackgo.UserGroup // groups by lowercase handle
+	mappedIDs      map[string]string            // slack user IDs by pagerduty user ID from the mapping file
+	profileIDs     map[string]string            // slack user IDs by pagerduty user ID from the custom profile field
+	usersByAlias   map[string][]slackgo.User    // active users by email with the domain aliases resolved
+	groupVersions  map[string]uint64            // version of the last write by lowercase handle, see Client.groupVersion
+}
+
+// newMasterData returns a snapshot with indexed channels, users and groups
+func newMasterData(channels []slackgo.Channel, users []slackgo.User, groups []slackgo.UserGroup) *masterData {
+	m := &masterData{
+		channelsByID:   make(map[string]slackgo.Channel, len(channels)),
+		channelsByName: make(map[string]slackgo.Channel, len(channels)),
+		usersByEmail:   make(map[string]slackgo.User, len(users)),
+		usersByID:      make(map[string]slackgo.User, len(users)),
+		groupsByHandle: make(map[string]slackgo.UserGroup, len(groups)),
+	}
+	for _, ch := range channels {
+		m.channelsByID[ch.ID] = ch
+		m.channelsByName[strings.ToLower(ch.Name)] = ch
+	}
+	for _, u := range users {
+		m.usersByID[u.ID] = u
+		email := strings.ToLower(u.Profile.Email)
+		if u.Deleted || email == "" {
+			continue
+		}
+		if _, ok := m.usersByEmail[email]; !ok {
+			m.usersByEmail[email] = u
+		}
+	}
+	for _, g := range groups {
+		m.groupsByHandle[strings.ToLower(g.Handle)] = g
+	}
+	return m
+}
+
+// channel returns the channel with the ID or name, case-insensitive and with or without leading '#'
+func (m *masterData) channel(idOrName string) (slackgo.Channel, bool) {
+	if ch, ok := m.channelsByID[idOrName]; ok {
+		return ch, true
+	}
+	ch, ok := m.channelsByName[strings.ToLower(strings.TrimPrefix(idOrName, "#"))]
+	return ch, ok
+}
+
+// userByEmail returns the active user with the email, case-insensitive
+func (m *masterData) userByEmail(email string) (slackgo.User, bool) {
+	u, ok := m.usersByEmail[strings.ToLower(email)]
+	return u, ok
+}
+
+// userByID returns the user with the ID
+func (m *masterData) userByID(id string) (slackgo.User, bool) {
+	u, ok := m.usersByID[id]
+	return u, ok
+}
+
+// userByPDID returns the active user with the slack user ID given for the pagerduty user ID
+func (m *masterData) userByPDID(slackIDs map[string]string, pdID string) (slackgo.User, bool) {
+	id, ok := slackIDs[pdID]
+	if !ok {
+		return slackgo.User{}, false
+	}
+	u, ok := m.userByID(id)
+	return u, ok && !u.Deleted
+}
+
+// group returns the group with the handle, case-insensitive
+func (m *masterData) group(handle string) (slackgo.UserGroup, bool) {
+	g, ok := m.groupsByHandle[strings.ToLower(handle)]
+	return g, ok
+}
+
+// withGroup returns a copy of the snapshot with the group added or replaced by a write with the version
+func (m *masterData) withGroup(g slackgo.UserGroup, version uint64) *masterData {
+	c := *m
+	c.groupsByHandle = make(map[string]slackgo.UserGroup, len(m.groupsByHandle)+1)
+	for handle, group := range m.groupsByHandle {
+		c.groupsByHandle[handle] = group
+	}
+	c.groupVersions = make(map[string]uint64, len(m.groupVersions)+1)
+	for handle, v := range m.groupVersions {
+		c.groupVersions[handle] = v
+	}
+	handle := strings.ToLower(g.Handle)
+	c.groupsByHandle[handle] = g
+	c.groupVersions[handle] = version
+	return &c
+}
+
+// withNewerGroups returns the snapshot with the groups of old written after the version, which are newer than
+// the groups of the snapshot
+func (m *masterData) withNewerGroups(old *masterData, version uint64) *masterData {
+	if old == nil {
+		return m
+	}
+	merged := m
+	for handle, v := range old.groupVersions {
+		if v > version {
+			merged = merged.withGroup(old.groupsByHandle[handle], v)
+		}
+	}
+	return merged
+}
+
+// withProfileIDs returns a copy of the snapshot with the slack user IDs by pagerduty user ID from the profile field
+func (m *masterData) withProfileIDs(ids map[string]string) *masterData {
+	c := *m
+	c.profileIDs = ids
+	return &c
+}
@@ -0,0 +1,341 @@
+package slack
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	pd "github.com/PagerDuty/go-pagerduty"
+	log "github.com/sirupsen/logrus"
+	slackgo "github.com/slack-go/slack"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sapcc/pagerduty2slack/internal/config"
+)
+
+// Match of a pagerduty user to a slack user
+type Match struct {
+	PDUser    pd.User
+	SlackUser slackgo.User
+	Strategy  config.MatchStrategy // which matched the users
+}
+
+// SlackUsers returns the slack users of the matches
+func SlackUsers(matches []Match) []slackgo.User {
+	users := make([]slackgo.User, 0, len(matches))
+	for _, m := range matches {
+		users = append(users, m.SlackUser)
+	}
+	return users
+}
+
+// matcher returns the slack user matching the pagerduty user, if any
+type matcher struct {
+	strategy config.MatchStrategy
+	match    func(m *masterData, u pd.User) (slackgo.User, bool)
+}
+
+// newMatchers returns the matchers of the configured strategies in order, matching by email if none is configured
+func newMatchers(cfg config.UserMatching) []matcher {
+	strategies := cfg.Strategies
+	if len(strategies) == 0 {
+		strategies = []config.MatchStrategy{config.MatchEmail}
+	}
+	var matchers []matcher
+	for _, strategy := range strategies {
+		var match func(m *masterData, u pd.User) (slackgo.User, bool)
+		switch strategy {
+		case config.MatchEmail:
+			match = matchEmail
+		case config.MatchEmailAlias:
+			aliases := cfg.DomainAliases
+			match = func(m *masterData, u pd.User) (slackgo.User, bool) { return matchEmailAlias(m, u, aliases) }
+		case config.MatchMappingFile:
+			match = func(m *masterData, u pd.User) (slackgo.User, bool) { return m.userByPDID(m.mappedIDs, u.ID) }
+		case config.MatchProfileField:
+			match = func(m *masterData, u pd.User) (slackgo.User, bool) { return m.userByPDID(m.profileIDs, u.ID) }
+		case config.MatchName:
+			threshold := cfg.NameSimilarity
+			match = func(m *masterData, u pd.User) (slackgo.User, bool) { return matchName(m, u, threshold) }
+		default:
+			continue
+		}
+		matchers = append(matchers, matcher{strategy: strategy, match: match})
+	}
+	return matchers
+}
+
+// matchPDToSlackUsers returns the slack users matching the pagerduty users by the first matching strategy
+func (m *masterData) matchPDToSlackUsers(pdUsers []pd.User, matchers []matcher) []Match {
+	var matches []Match
+	for _, u := range pdUsers {
+		matched := false
+		for _, mr := range matchers {
+			slackUser, ok := mr.match(m, u)
+			if !ok {
+				continue
+			}
+			if mr.strategy == config.MatchName {
+				log.Warnf("slack: pagerduty user %s matched to slack user %s (%s) by name only, check the match", u.Name, slackUser.RealName, slackUser.ID)
+			} else {
+				log.Debugf("slack: pagerduty user %s matched to slack user %s by %s", u.Name, slackUser.ID, mr.strategy)
+			}
+			matches = append(matches, Match{PDUser: u, SlackUser: slackUser, Strategy: mr.strategy})
+			matched = true
+			break
+		}
+		if !matched {
+			log.Warnf("slack: no slack user found for pagerduty user %s %s", u.Name, u.HTMLURL)
+		}
+	}
+	return matches
+}
+
+// matchEmail matches the same email, case-insensitive
+func matchEmail(m *masterData, u pd.User) (slackgo.User, bool) {
+	if u.Email == "" {
+		return slackgo.User{}, false
+	}
+	return m.userByEmail(u.Email)
+}
+
+// matchEmailAlias matches the same local part of the email if the domains are the same after resolving the aliases.
+// If several slack users share the resolved email the match is ambiguous and skipped.
+func matchEmailAlias(m *masterData, u pd.User, aliases map[string]string) (slackgo.User, bool) {
+	email := canonicalEmail(u.Email, aliases)
+	if email == "" {
+		return slackgo.User{}, false
+	}
+	users := m.usersByAlias[email]
+	if len(users) > 1 {
+		log.Warnf("slack: pagerduty user %s matches several slack users by email alias %s, skipped", u.Name, email)
+		return slackgo.User{}, false
+	}
+	if len(users) == 0 {
+		return slackgo.User{}, false
+	}
+	return users[0], true
+}
+
+// indexByCanonicalEmail returns the users by their email with the domain aliases resolved, sorted by ID
+func indexByCanonicalEmail(usersByEmail map[string]slackgo.User, aliases map[string]string) map[string][]slackgo.User {
+	index := make(map[string][]slackgo.User, len(usersByEmail))
+	for email, u := range usersByEmail {
+		if canonical := canonicalEmail(email, aliases); canonical != "" {
+			index[canonical] = append(index[canonical], u)
+		}
+	}
+	for _, users := range index {
+		sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	}
+	return index
+}
+
+// canonicalEmail returns the lowercase email with the domain replaced by its canonical domain, empty if invalid
+func canonicalEmail(email string, aliases map[string]string) string {
+	local, domain, ok := strings.Cut(strings.ToLower(email), "@")
+	if !ok || local == "" {
+		return ""
+	}
+	for alias, canonical := range aliases {
+		if strings.EqualFold(alias, domain) {
+			domain = strings.ToLower(canonical)
+			break
+		}
+	}
+	return local + "@" + domain
+}
+
+// matchName matches the active slack user with the most similar real name, if the similarity reaches the threshold
+// and no other user is as similar
+func matchName(m *masterData, u pd.User, threshold float64) (slackgo.User, bool) {
+	name := normalizeName(u.Name)
+	if name == "" {
+		return slackgo.User{}, false
+	}
+	var best slackgo.User
+	bestSimilarity, ambiguous := 0.0, false
+	for _, slackUser := range m.usersByEmail {
+		similarity := nameSimilarity(name, normalizeName(slackUser.RealName))
+		switch {
+		case similarity < threshold || similarity < bestSimilarity:
+		case similarity == bestSimilarity:
+			ambiguous = true
+		default:
+			best, bestSimilarity, ambiguous = slackUser, similarity, false
+		}
+	}
+	if ambiguous {
+		log.Warnf("slack: pagerduty user %s matches several slack users by name, skipped", u.Name)
+		return slackgo.User{}, false
+	}
+	return best, bestSimilarity > 0
+}
+
+// normalizeName returns the lowercase words of the name sorted, so "Doe, John" and "john doe" are equal
+func normalizeName(name string) string {
+	words := strings.Fields(strings.ToLower(strings.NewReplacer(",", " ", ".", " ", "-", " ").Replace(name)))
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// nameSimilarity returns 1 for equal names down to 0 for completely different names based on the edit distance
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(longest)
+}
+
+// editDistance returns the Levenshtein distance of a and b
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// readMappingFile returns the slack user IDs by pagerduty user ID of the yaml file
+func readMappingFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("slack: reading user mapping file failed: %w", err)
+	}
+	mapping := make(map[string]string)
+	if err := yaml.Unmarshal(b, &mapping); err != nil {
+		return nil, fmt.Errorf("slack: parsing user mapping file '%s' failed: %w", path, err)
+	}
+	return mapping, nil
+}
+
+// profileTTL is how long the pagerduty user ID in the profile of a slack user is cached
+const profileTTL = 24 * time.Hour
+
+// profileTimeout is the deadline of requesting a single profile in the background
+const profileTimeout = time.Minute
+
+// profileField is the cached pagerduty user ID in the custom profile field of a slack user
+type profileField struct {
+	pdID     string    // empty if the field is not set
+	loadedAt time.Time // when the profile was requested
+}
+
+// profileIDs returns the slack user IDs by the pagerduty user ID in the custom profile field of the active users,
+// taken from users.list if it returned the custom fields and else from the cached profiles. It also returns the
+// users whose profile is not cached yet or expired, expired entries are used until they are requested again.
+func (c *Client) profileIDs(usersByID map[string]slackgo.User) (map[string]string, []slackgo.User) {
+	fieldID := c.matching.ProfileFieldID
+	c.profileMutex.Lock()
+	defer c.profileMutex.Unlock()
+
+	ids := make(map[string]string)
+	var missing []slackgo.User
+	for _, u := range usersByID {
+		if u.Deleted || u.IsBot {
+			continue
+		}
+		var pdID string
+		if field, ok := u.Profile.FieldsMap()[fieldID]; ok {
+			pdID = pdIDFromField(field.Value)
+		} else {
+			cached, ok := c.profilePDIDs[u.ID]
+			if !ok || time.Since(cached.loadedAt) >= profileTTL {
+				missing = append(missing, u)
+			}
+			pdID = cached.pdID
+		}
+		// the same pagerduty user in several profiles resolves to the lowest slack user ID
+		if other, ok := ids[pdID]; pdID != "" && (!ok || u.ID < other) {
+			ids[pdID] = u.ID
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].ID < missing[j].ID })
+	return ids, missing
+}
+
+// loadProfiles requests the profiles of the users in the background unless a load is already running, so the
+// master data is available before all profiles are known. Users whose profile can't be retrieved are skipped and
+// requested again with the next master data load. The profile IDs of the master data are updated afterwards. The
+// load stops when the client is closed on shutdown.
+func (c *Client) loadProfiles(users []slackgo.User) {
+	if len(users) == 0 || !c.profileLoading.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer c.profileLoading.Store(false)
+		fieldID := c.matching.ProfileFieldID
+		failed := 0
+		for i, u := range users {
+			if c.ctx.Err() != nil {
+				log.Debugf("slack: loading profiles stopped: %s", c.ctx.Err().Error())
+				failed += len(users) - i
+				break
+			}
+			ctx, cancel := context.WithTimeout(c.ctx, profileTimeout)
+			profile, err := c.bot().GetUserProfileContext(ctx, &slackgo.GetUserProfileParameters{UserID: u.ID})
+			cancel()
+			if err != nil {
+				log.Debugf("slack: retrieving profile of user '%s' failed: %s", u.ID, err.Error())
+				failed++
+				continue
+			}
+			c.profileMutex.Lock()
+			if c.profilePDIDs == nil {
+				c.profilePDIDs = make(map[string]profileField)
+			}
+			c.profilePDIDs[u.ID] = profileField{pdID: pdIDFromField(profile.FieldsMap()[fieldID].Value), loadedAt: time.Now()}
+			c.profileMutex.Unlock()
+		}
+		if failed > 0 {
+			log.Warnf("slack: retrieving the profiles of %d of %d users failed, they are retried with the next master data load", failed, len(users))
+		}
+		for {
+			old := c.data.Load()
+			ids, _ := c.profileIDs(old.usersByID)
+			if c.data.CompareAndSwap(old, old.withProfileIDs(ids)) {
+				break
+			}
+		}
+		log.Debugf("slack: profiles of %d users loaded", len(users)-failed)
+	}()
+}
+
+// pdIDFromField returns the pagerduty user ID of the profile field, which may also contain the profile URL
+func pdIDFromField(value string) string {
+	value = strings.TrimRight(strings.TrimSpace(value), "/")
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		value = value[i+1:]
+	}
+	return value
+}
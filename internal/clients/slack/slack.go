@@ -1,255 +1,629 @@
 package slack
 
 import (
+	"context"
 	"fmt"
+	"html"
+	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	pd "github.com/PagerDuty/go-pagerduty"
 	log "github.com/sirupsen/logrus"
 	slackgo "github.com/slack-go/slack"
 
 	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/retry"
 )
 
 type Client struct {
-	botClient     *slackgo.Client     // slack client for bot
-	userClient    *slackgo.Client     // slack client for user
-	users         []slackgo.User      // list of all slack users in the workspace
-	groups        []slackgo.UserGroup // list of all groups in the workspace
-	infoChannel   *slackgo.Channel    // channel used to post info messages to
-	infoChannelID string              // ID of info channel
+	cfg           config.SlackConfig         // credentials to (re-)create the api clients
+	apiMutex      sync.RWMutex               // guards the api clients replaced on credential rotation
+	botClient     *slackgo.Client            // slack client for bot
+	userClient    *slackgo.Client            // slack client for user
+	data          atomic.Pointer[masterData] // snapshot of channels, users and groups, swapped on reload
+	groupVersion  atomic.Uint64              // incremented by every group write, a reload keeps later writes
+	infoChannelID string                     // ID of info channel
+	retryPolicy   retry.Policy               // of failed api requests
+	ctx           context.Context            // of the requests in the background, cancelled by Close
+	stop          context.CancelFunc         // cancels ctx
+
+	threadMutex sync.Mutex        // serializes looking up and creating thread parents
+	threads     map[string]string // timestamps of the thread parent messages by channel ID and text
+
+	dmInterval time.Duration        // how often the same direct message is sent to a user at most
+	dmMutex    sync.Mutex           // guards dmSent
+	dmSent     map[string]time.Time // last direct message by user ID and topic
+
+	matching       config.UserMatching     // strategies matching pagerduty users to slack users
+	matchers       []matcher               // of the strategies in order
+	profileMutex   sync.Mutex              // guards profilePDIDs
+	profilePDIDs   map[string]profileField // pagerduty user IDs in the custom profile field by slack user ID
+	profileLoading atomic.Bool             // whether profiles are loaded in the background
 }
 
 // newAPIClient returns token specific slack client object and tests auth
-func newAPIClient(token string, options ...slackgo.Option) (*slackgo.Client, error) {
+func newAPIClient(ctx context.Context, token string, options ...slackgo.Option) (*slackgo.Client, error) {
 	options = append(options, slackgo.OptionDebug(false))
 	c := slackgo.New(token, options...)
-	_, err := c.AuthTest()
+	_, err := c.AuthTestContext(ctx)
 	return c, err
 }
 
+// apiOptions returns the options for api clients retrying failed requests, using the configured api url if any
+func (c *Client) apiOptions() []slackgo.Option {
+	options := []slackgo.Option{slackgo.OptionHTTPClient(retry.NewClient(&http.Client{}, c.retryPolicy))}
+	if c.cfg.APIURL != "" {
+		options = append(options, slackgo.OptionAPIURL(c.cfg.APIURL))
+	}
+	return options
+}
+
+// bot returns the slack client for the bot
+func (c *Client) bot() *slackgo.Client {
+	c.apiMutex.RLock()
+	defer c.apiMutex.RUnlock()
+	return c.botClient
+}
+
+// user returns the slack client for the user
+func (c *Client) user() *slackgo.Client {
+	c.apiMutex.RLock()
+	defer c.apiMutex.RUnlock()
+	return c.userClient
+}
+
+// RefreshCredentials re-reads the tokens and recreates the api clients if they changed
+func (c *Client) RefreshCredentials(ctx context.Context) error {
+	c.apiMutex.Lock()
+	defer c.apiMutex.Unlock()
+
+	cfg := c.cfg
+	changed, err := cfg.LoadSecrets()
+	if err != nil {
+		return fmt.Errorf("slack: reading credentials failed: %w", err)
+	}
+	if !changed {
+		return nil
+	}
+
+	bot, err := newAPIClient(ctx, cfg.BotSecurityToken, c.apiOptions()...)
+	if err != nil {
+		return fmt.Errorf("slack: failed creating bot client with rotated token: %w", err)
+	}
+	user, err := newAPIClient(ctx, cfg.UserSecurityToken, c.apiOptions()...)
+	if err != nil {
+		return fmt.Errorf("slack: failed creating user client with rotated token: %w", err)
+	}
+	c.cfg, c.botClient, c.userClient = cfg, bot, user
+	log.Info("slack: credentials rotated")
+	return nil
+}
+
 // PostBlocksMessage takes the blocks and sends them to the default info channel
-func (c *Client) PostBlocksMessage(blocks ...slackgo.Block) error {
+func (c *Client) PostBlocksMessage(ctx context.Context, blocks ...slackgo.Block) error {
 	opts := slackgo.MsgOptionBlocks(blocks...)
-	return c.PostMessage(opts)
+	return c.PostMessage(ctx, opts)
 }
 
 // PostMessage takes the message options sends it to the info channel
-func (c *Client) PostMessage(opts slackgo.MsgOption) error {
-	if _, _, err := c.botClient.PostMessage(c.infoChannel.ID, opts); err != nil {
-		return fmt.Errorf("slack: failed posting message: %w", err)
+func (c *Client) PostMessage(ctx context.Context, opts slackgo.MsgOption) error {
+	_, err := c.PostMessageTo(ctx, "", opts)
+	return err
+}
+
+// PostMessageTo sends the message to the channel (found by ID or name), the info channel if empty. It returns the
+// timestamp of the message.
+func (c *Client) PostMessageTo(ctx context.Context, channel string, opts ...slackgo.MsgOption) (string, error) {
+	ch, err := c.GetChannel(channel)
+	if err != nil {
+		return "", fmt.Errorf("slack: failed posting message: %w", err)
 	}
-	log.Debug("slack: message successfully sent to channel ", c.infoChannel.Name)
-	return nil
+	_, ts, err := c.bot().PostMessageContext(ctx, ch.ID, opts...)
+	if err != nil {
+		return "", fmt.Errorf("slack: failed posting message to channel '%s': %w", ch.Name, err)
+	}
+	log.Debug("slack: message successfully sent to channel ", ch.Name)
+	return ts, nil
 }
 
-// NewClient returns a new slackclient with intialized bot & user client and loaded masterdata
-func NewClient(cfg *config.SlackConfig) (*Client, error) {
-	bot, err := newAPIClient(cfg.BotSecurityToken)
+// SendDirectMessage sends the message to the user as direct message from the bot. The same topic is sent to a user
+// at most once per direct message interval. It returns false if the message was not sent for that reason.
+func (c *Client) SendDirectMessage(ctx context.Context, userID, topic string, opts ...slackgo.MsgOption) (bool, error) {
+	key := userID + "/" + topic
+	// reserve the message, so the lock isn't held during the request and concurrent sends of it are skipped
+	c.dmMutex.Lock()
+	if c.dmSent == nil {
+		c.dmSent = make(map[string]time.Time)
+	}
+	last, sent := c.dmSent[key]
+	if sent && time.Since(last) < c.dmInterval {
+		c.dmMutex.Unlock()
+		return false, nil
+	}
+	c.dmSent[key] = time.Now()
+	c.dmMutex.Unlock()
+
+	// posting to the user ID opens the direct message channel with the bot
+	if _, _, err := c.bot().PostMessageContext(ctx, userID, opts...); err != nil {
+		c.dmMutex.Lock()
+		if sent {
+			c.dmSent[key] = last
+		} else {
+			delete(c.dmSent, key)
+		}
+		c.dmMutex.Unlock()
+		return false, fmt.Errorf("slack: failed sending direct message to user '%s': %w", userID, err)
+	}
+	log.Debugf("slack: direct message '%s' sent to user %s", topic, userID)
+	return true, nil
+}
+
+// GetChannel returns the channel (found by ID or name), the info channel if empty
+func (c *Client) GetChannel(channel string) (slackgo.Channel, error) {
+	if channel == "" {
+		channel = c.infoChannelID
+	}
+	ch, ok := c.masterData().channel(channel)
+	if !ok {
+		return slackgo.Channel{}, fmt.Errorf("slack: channel '%s' not found, the bot must be member of private channels", channel)
+	}
+	return ch, nil
+}
+
+// ThreadParent returns the timestamp of the message with the text pinned to the channel (found by ID or name), the
+// info channel if empty. The message is posted and pinned if it doesn't exist yet.
+func (c *Client) ThreadParent(ctx context.Context, channel, text string) (string, error) {
+	ch, err := c.GetChannel(channel)
 	if err != nil {
-		return nil, fmt.Errorf("slack: failed creating bot client: %w", err)
+		return "", err
+	}
+
+	c.threadMutex.Lock()
+	defer c.threadMutex.Unlock()
+	if c.threads == nil {
+		c.threads = make(map[string]string)
 	}
-	user, err := newAPIClient(cfg.UserSecurityToken)
+	key := ch.ID + "/" + text
+	if ts, ok := c.threads[key]; ok {
+		return ts, nil
+	}
+
+	// the parent is pinned to find it again after a restart
+	pins, _, err := c.bot().ListPinsContext(ctx, ch.ID)
 	if err != nil {
-		return nil, fmt.Errorf("slack: failed creating user client: %w", err)
+		return "", fmt.Errorf("slack: listing pinned messages of channel '%s' failed: %w", ch.Name, err)
+	}
+	for _, item := range pins {
+		// slack returns the text with &, < and > escaped
+		if item.Message != nil && html.UnescapeString(item.Message.Text) == text {
+			c.threads[key] = item.Message.Timestamp
+			return item.Message.Timestamp, nil
+		}
 	}
 
+	ts, err := c.PostMessageTo(ctx, ch.ID, slackgo.MsgOptionText(text, false))
+	if err != nil {
+		return "", err
+	}
+	if err := c.bot().AddPinContext(ctx, ch.ID, slackgo.ItemRef{Channel: ch.ID, Timestamp: ts}); err != nil {
+		return "", fmt.Errorf("slack: pinning message to channel '%s' failed: %w", ch.Name, err)
+	}
+	c.threads[key] = ts
+	log.Infof("slack: pinned thread parent to channel '%s'", ch.Name)
+	return ts, nil
+}
+
+// NewClient returns a new slackclient with intialized bot & user client retrying failed requests according to the policy and loaded masterdata
+func NewClient(ctx context.Context, cfg *config.SlackConfig, retryPolicy retry.Policy) (*Client, error) {
 	c := &Client{
-		botClient:     bot,
-		userClient:    user,
+		cfg:           *cfg,
 		infoChannelID: cfg.InfoChannelID,
+		retryPolicy:   retryPolicy,
+		dmInterval:    cfg.DirectMessageInterval,
+		matching:      cfg.UserMatching,
+		matchers:      newMatchers(cfg.UserMatching),
 	}
 
-	err = c.LoadMasterData()
+	bot, err := newAPIClient(ctx, cfg.BotSecurityToken, c.apiOptions()...)
 	if err != nil {
+		return nil, fmt.Errorf("slack: failed creating bot client: %w", err)
+	}
+	user, err := newAPIClient(ctx, cfg.UserSecurityToken, c.apiOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("slack: failed creating user client: %w", err)
+	}
+	c.botClient, c.userClient = bot, user
+	c.ctx, c.stop = context.WithCancel(ctx)
+
+	err = c.LoadMasterData(ctx)
+	if err != nil {
+		c.Close()
 		return nil, fmt.Errorf("slack: failed loading masterdata: %w", err)
 	}
 	return c, nil
 }
 
-// LoadMasterData singleton master data to speed up
-func (c *Client) LoadMasterData() (err error) {
-	slackChannelsTemp, err := c.botClient.GetConversationInfo(c.infoChannelID, true)
+// Close stops the requests in the background, e.g. loading profiles, on shutdown. Requests of running jobs are not
+// affected.
+func (c *Client) Close() {
+	c.stop()
+}
+
+// LoadMasterData loads the channels, users and groups and replaces the master data snapshot
+func (c *Client) LoadMasterData(ctx context.Context) (err error) {
+	channels, err := c.listChannels(ctx)
 	if err != nil {
-		return fmt.Errorf("slack: failed retrieving info channel '%s': %w", c.infoChannelID, err)
+		return fmt.Errorf("slack: failed retrieving channels: %w", err)
 	}
-	c.infoChannel = slackChannelsTemp
 
-	slackUserListTemp, err := c.botClient.GetUsers()
+	users, err := c.bot().GetUsersContext(ctx)
 	if err != nil {
 		return fmt.Errorf("slack: failed retrieving users: %w", err)
 	}
 
-	slackGrpsTemp, err := c.botClient.GetUserGroups(slackgo.GetUserGroupsOptionIncludeUsers(true))
+	// groups written while they are listed are newer than the listed ones
+	listed := c.groupVersion.Load()
+	// disabled groups are included, they are enabled again once somebody is on shift
+	groups, err := c.bot().GetUserGroupsContext(ctx, slackgo.GetUserGroupsOptionIncludeUsers(true), slackgo.GetUserGroupsOptionIncludeDisabled(true))
 	if err != nil {
 		return fmt.Errorf("slack: failed retrieving user groups: %w", err)
 	}
 
-	var mutex = &sync.Mutex{}
-	mutex.Lock()
-	c.users = slackUserListTemp
-	c.groups = slackGrpsTemp
-	mutex.Unlock()
+	data := newMasterData(channels, users, groups)
+	if _, ok := data.channel(c.infoChannelID); !ok {
+		return fmt.Errorf("slack: failed retrieving info channel '%s': not found, the bot must be member of private channels", c.infoChannelID)
+	}
+	if c.matching.Uses(config.MatchMappingFile) {
+		if data.mappedIDs, err = readMappingFile(c.matching.MappingFile); err != nil {
+			return err
+		}
+	}
+	if c.matching.Uses(config.MatchEmailAlias) {
+		data.usersByAlias = indexByCanonicalEmail(data.usersByEmail, c.matching.DomainAliases)
+	}
+	var missingProfiles []slackgo.User
+	if c.matching.Uses(config.MatchProfileField) {
+		data.profileIDs, missingProfiles = c.profileIDs(data.usersByID)
+	}
+	for {
+		old := c.data.Load()
+		if c.data.CompareAndSwap(old, data.withNewerGroups(old, listed)) {
+			break
+		}
+	}
+	c.loadProfiles(missingProfiles)
 	log.Debug("slack: masterdata successfully updated")
 	return nil
 }
 
+// listChannels returns all not archived public and private channels visible to the bot
+func (c *Client) listChannels(ctx context.Context) ([]slackgo.Channel, error) {
+	params := &slackgo.GetConversationsParameters{
+		ExcludeArchived: true,
+		Limit:           1000,
+		Types:           []string{"public_channel", "private_channel"},
+	}
+	var channels []slackgo.Channel
+	for {
+		page, cursor, err := c.bot().GetConversationsContext(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, page...)
+		if cursor == "" {
+			return channels, nil
+		}
+		params.Cursor = cursor
+	}
+}
+
+// masterData returns the current snapshot of the master data, empty if not loaded yet
+func (c *Client) masterData() *masterData {
+	if m := c.data.Load(); m != nil {
+		return m
+	}
+	return newMasterData(nil, nil, nil)
+}
+
 // GetSlackGroup requests existing Group for given name
 func (c *Client) GetSlackGroup(slackGroupHandle string) (slackgo.UserGroup, error) {
 	if slackGroupHandle == "" {
 		return slackgo.UserGroup{}, fmt.Errorf("slack: finding group failed, empty handle")
 	}
 
-	// get the group we are interested in
-	var targetGroup slackgo.UserGroup
-	for _, g := range c.groups {
-		if strings.EqualFold(g.Handle, slackGroupHandle) {
-			targetGroup = g
-			break
-		}
-	}
-
-	if targetGroup.Handle == "" {
+	targetGroup, ok := c.masterData().group(slackGroupHandle)
+	if !ok {
 		return slackgo.UserGroup{}, fmt.Errorf("slack: finding group handle '%s' failed. check config", slackGroupHandle)
 	}
 
 	return targetGroup, nil
 }
 
+// RefreshGroup reloads the members of the group (found by handle) and updates the master data
+func (c *Client) RefreshGroup(ctx context.Context, slackGroupHandle string) (slackgo.UserGroup, error) {
+	group, err := c.GetSlackGroup(slackGroupHandle)
+	if err != nil {
+		return slackgo.UserGroup{}, err
+	}
+	// members of disabled groups can't be listed, they don't change until the group is enabled again
+	if isDisabled(group) {
+		return group, nil
+	}
+	members, err := c.bot().GetUserGroupMembersContext(ctx, group.ID)
+	if err != nil {
+		return slackgo.UserGroup{}, fmt.Errorf("slack: retrieving members of group %s[%s] failed: %w", group.Name, group.ID, err)
+	}
+	group.Users, group.UserCount = members, len(members)
+	c.storeGroup(group)
+	return group, nil
+}
+
+// storeGroup replaces the group in the master data snapshot with the state returned by a write. Members
+// missing in the response are kept from the cached group.
+func (c *Client) storeGroup(group slackgo.UserGroup) {
+	if group.Handle == "" {
+		log.Debugf("slack: group %s without handle in response, not cached", group.ID)
+		return
+	}
+	version := c.groupVersion.Add(1)
+	for {
+		old := c.data.Load()
+		if old == nil {
+			return
+		}
+		g := group
+		if cached, ok := old.group(g.Handle); ok && g.Users == nil {
+			g.Users, g.UserCount = cached.Users, cached.UserCount
+		}
+		if c.data.CompareAndSwap(old, old.withGroup(g, version)) {
+			return
+		}
+	}
+}
+
 // MatchPDUsers returns slack users matching the given pagerduty users
-func (c *Client) MatchPDUsers(pdUsers []pd.User) ([]slackgo.User, error) {
+func (c *Client) MatchPDUsers(ctx context.Context, pdUsers []pd.User) ([]slackgo.User, error) {
+	matches, err := c.Match(ctx, pdUsers)
+	if err != nil {
+		return nil, err
+	}
+	return SlackUsers(matches), nil
+}
+
+// Match returns the slack users matching the given pagerduty users with the strategy which matched them. The
+// strategies are tried in the configured order, users matched by none are skipped.
+func (c *Client) Match(ctx context.Context, pdUsers []pd.User) ([]Match, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	// if no pdUsers given, we don't need to filter
-	if pdUsers == nil {
-		log.Warn("empty PD user list given!")
-		return nil, fmt.Errorf("empty PD user list; check shift schedule")
+	if len(pdUsers) == 0 {
+		log.Info("slack: empty PD user list given, nobody on shift")
+		return nil, nil
+	}
+
+	matchers := c.matchers
+	if matchers == nil {
+		matchers = newMatchers(config.UserMatching{})
 	}
+	// some people are not in slack
+	matches := c.masterData().matchPDToSlackUsers(pdUsers, matchers)
 
-	// get all SLACK User Ids which are in our PD Group - some people are not in slack
-	userList := c.matchPDToSlackUsers(pdUsers)
+	log.Infof("slack: found #%v matching slack user(s) for #%v user(s) in PD group", len(matches), len(pdUsers))
+	return matches, nil
+}
 
-	log.Infof("slack: found #%v matching slack user(s) for #%v user(s) in PD group", len(userList), len(pdUsers))
-	return userList, nil
+// GroupChange describes the members added to, removed from and kept in a slack user group
+type GroupChange struct {
+	Added     []slackgo.User // users which are not yet members
+	Removed   []slackgo.User // members which are not in the synced users
+	Unchanged []slackgo.User // members which stay in the group
+	Enabled   bool           // the group was disabled and is enabled again
+	Disabled  bool           // the group is disabled as nobody is on shift
 }
 
-// AddToGroup sets an array of Slack User to an Slack Group (found by name), returns true if noop
-func (c *Client) AddToGroup(groupHandle string, slackUsers []slackgo.User, dryrun bool) (noChange bool, err error) {
-	noChange = true
+// HasChanges is true when users are added or removed or the group is enabled or disabled
+func (g GroupChange) HasChanges() bool {
+	return len(g.Added) > 0 || len(g.Removed) > 0 || g.Enabled || g.Disabled
+}
 
-	// get the group we are interested in
-	userGroupBefore, err := c.GetSlackGroup(groupHandle)
-	if err != nil {
-		return true, fmt.Errorf("slack: retrieving slack group '%s' failed: %w", groupHandle, err)
-	}
+// Members returns the users which are members after applying the change
+func (g GroupChange) Members() []slackgo.User {
+	members := make([]slackgo.User, 0, len(g.Added)+len(g.Unchanged))
+	members = append(members, g.Unchanged...)
+	return append(members, g.Added...)
+}
 
+// AddToGroup sets the slack users as members of the slack group (found by handle) and returns the applied change.
+// In dry run the change is only computed.
+func (c *Client) AddToGroup(ctx context.Context, groupHandle string, slackUsers []slackgo.User, dryrun bool) (GroupChange, error) {
 	if len(slackUsers) == 0 {
-		return true, fmt.Errorf("slack: user list empty; no update done")
+		return GroupChange{}, fmt.Errorf("slack: user list empty; no update done")
 	}
 
-	// we need a list of IDs
-	var slackUserIds []string
-
-	if len(slackUsers) == len(userGroupBefore.Users) {
-		for _, user := range slackUsers {
-			if !groupContainsUser(userGroupBefore.Users, user) {
-				slackUserIds = append(slackUserIds, user.ID)
-				noChange = false
-				continue
-			}
-			slackUserIds = append(slackUserIds, user.ID)
-		}
-	} else {
-		noChange = false
-		for _, u := range slackUsers {
-			slackUserIds = append(slackUserIds, u.ID)
-		}
+	// get the current members of the group we are interested in
+	group, err := c.RefreshGroup(ctx, groupHandle)
+	if err != nil {
+		return GroupChange{}, fmt.Errorf("slack: retrieving slack group '%s' failed: %w", groupHandle, err)
 	}
 
-	if noChange {
-		slackUserIds = nil
+	change := c.diffGroup(group, slackUsers)
+	change.Enabled = isDisabled(group)
+	if !change.HasChanges() {
+		log.Infof("slack: group '%s' is up to date (%d member(s))", group.Name, len(change.Unchanged))
+		return change, nil
+	}
+	if dryrun {
+		log.Infof("slack: dry run. would add %v to and remove %v from group '%s', enable: %v", userIDs(change.Added), userIDs(change.Removed), group.Name, change.Enabled)
+		return change, nil
 	}
 
-	var userGroupAfter slackgo.UserGroup
-	if !dryrun && !noChange {
-		userGroupAfter, err = c.userClient.UpdateUserGroupMembers(userGroupBefore.ID, strings.Join(slackUserIds, ","))
+	// enabled first, members of disabled groups can't be updated
+	if change.Enabled {
+		enabled, err := c.user().EnableUserGroupContext(ctx, group.ID)
 		if err != nil {
-			return noChange, fmt.Errorf("slack: writing changes for user group %s[%s] failed: %s", userGroupBefore.Name, userGroupBefore.ID, err.Error())
+			return GroupChange{}, fmt.Errorf("slack: enabling user group %s[%s] failed: %s", group.Name, group.ID, err.Error())
 		}
+		c.storeGroup(enabled)
+		log.Infof("slack: enabled slack user group %s[%s]", group.Name, group.ID)
+	}
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return change, nil
+	}
+
+	updated, err := c.user().UpdateUserGroupMembersContext(ctx, group.ID, strings.Join(userIDs(change.Members()), ","))
+	if err != nil {
+		return GroupChange{}, fmt.Errorf("slack: writing changes for user group %s[%s] failed: %s", group.Name, group.ID, err.Error())
+	}
+	c.storeGroup(updated)
+	log.Infof("slack: added %v to and removed %v from group '%s'(%d member(s))", userIDs(change.Added), userIDs(change.Removed), updated.Name, len(updated.Users))
+	return change, nil
+}
+
+// DiffGroup returns the change setting the given users as members of the group (found by handle) would apply
+func (c *Client) DiffGroup(groupHandle string, slackUsers []slackgo.User) (GroupChange, error) {
+	group, err := c.GetSlackGroup(groupHandle)
+	if err != nil {
+		return GroupChange{}, fmt.Errorf("slack: retrieving slack group '%s' failed: %w", groupHandle, err)
+	}
+	change := c.diffGroup(group, slackUsers)
+	change.Enabled = isDisabled(group)
+	return change, nil
+}
 
-		log.Infof("slack: updated %s successfully", userGroupAfter.Name)
+// diffGroup compares the members of the group with the given users. Duplicate users, e.g. on call in
+// multiple schedules, are counted once and the order of both lists doesn't matter.
+func (c *Client) diffGroup(group slackgo.UserGroup, slackUsers []slackgo.User) GroupChange {
+	members := make(map[string]struct{}, len(group.Users))
+	for _, id := range group.Users {
+		members[id] = struct{}{}
+	}
 
-		if userGroupAfter.DateDelete.String() == "" {
-			_, err = c.userClient.EnableUserGroup(userGroupAfter.ID)
-			if err != nil {
-				return noChange, fmt.Errorf("slack: enabling user group %s[%s] failed: %s", userGroupBefore.Name, userGroupBefore.ID, err.Error())
-			}
+	var change GroupChange
+	targetIDs := make(map[string]struct{}, len(slackUsers))
+	for _, u := range slackUsers {
+		if _, ok := targetIDs[u.ID]; ok {
+			continue
 		}
-	} else {
-		userGroupAfter = userGroupBefore
-	}
-
-	var removedUsers []string
-	if !noChange {
-		for _, u := range userGroupBefore.Users {
-			var removed = true
-			for _, n := range userGroupAfter.Users {
-				if u == n {
-					removed = false
-					break
-				}
-			}
-			if removed {
-				removedUsers = append(removedUsers, u)
-			}
+		targetIDs[u.ID] = struct{}{}
+		if _, ok := members[u.ID]; ok {
+			change.Unchanged = append(change.Unchanged, u)
+		} else {
+			change.Added = append(change.Added, u)
 		}
 	}
+	for _, id := range group.Users {
+		if _, ok := targetIDs[id]; ok {
+			continue
+		}
+		// mark as handled, the group may list a member twice
+		targetIDs[id] = struct{}{}
+		change.Removed = append(change.Removed, c.getUserByID(id))
+	}
+	return change
+}
 
-	if dryrun {
-		log.Infof("slack: dry run. no changes executed.")
+// userIDs returns the IDs of the users
+func userIDs(users []slackgo.User) []string {
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
 	}
-	log.Infof("slack: added %v to and removed %v from group '%s'(%d member(s))", slackUserIds, removedUsers, userGroupAfter.Name, len(userGroupAfter.Users))
+	return ids
+}
 
-	return noChange, nil
+// getUserByID returns the slack user for the ID from the master data or a user only containing the ID
+func (c *Client) getUserByID(id string) slackgo.User {
+	if u, ok := c.masterData().userByID(id); ok {
+		return u
+	}
+	return slackgo.User{ID: id}
 }
 
-func (c *Client) DisableGroup(groupID string) error {
-	userGroup, err := c.userClient.DisableUserGroup(groupID)
+// KeepGroup returns the current members of the group (found by handle) as unchanged
+func (c *Client) KeepGroup(ctx context.Context, groupHandle string) (GroupChange, error) {
+	group, err := c.RefreshGroup(ctx, groupHandle)
 	if err != nil {
-		return err
+		return GroupChange{}, fmt.Errorf("slack: retrieving slack group '%s' failed: %w", groupHandle, err)
 	}
-	log.Infof("slack: disabled slack user group %s[%s]", userGroup.Name, userGroup.ID)
-	return nil
+	return c.diffGroup(group, c.groupMembers(group)), nil
 }
 
-// groupContainsUser returns true user is contained in the groupUserIDs
-func groupContainsUser(groupUserIDs []string, user slackgo.User) bool {
-	if len(groupUserIDs) == 0 {
-		return false
+// GroupMembers returns the current members of the group (found by handle)
+func (c *Client) GroupMembers(ctx context.Context, groupHandle string) ([]slackgo.User, error) {
+	group, err := c.RefreshGroup(ctx, groupHandle)
+	if err != nil {
+		return nil, fmt.Errorf("slack: retrieving slack group '%s' failed: %w", groupHandle, err)
 	}
-	for _, id := range groupUserIDs {
-		if id == user.ID {
-			return true
+	return c.groupMembers(group), nil
+}
+
+// UsersByID returns the users with the IDs, it fails if any is unknown
+func (c *Client) UsersByID(ids []string) ([]slackgo.User, error) {
+	data := c.masterData()
+	users := make([]slackgo.User, 0, len(ids))
+	for _, id := range ids {
+		u, ok := data.userByID(id)
+		if !ok {
+			return nil, fmt.Errorf("slack: user '%s' not found", id)
 		}
+		users = append(users, u)
 	}
-	return false
+	return users, nil
 }
 
-// matchPDToSlackUsers returns a list of valid Slack users that match the list of PagerDuty users
-func (c *Client) matchPDToSlackUsers(pdUsers []pd.User) []slackgo.User {
-	var matchedSlackUsers []slackgo.User
-	for _, pd := range pdUsers {
-		if pd.Email == "" {
-			log.Infof("pagerduty: skipping user %s, no email assigned", pd.Name)
-			continue
-		}
-		for _, u := range c.users {
-			if u.Deleted {
-				continue
-			}
-			if strings.EqualFold(pd.Email, u.Profile.Email) {
-				matchedSlackUsers = append(matchedSlackUsers, u)
-			}
+// UsersByEmail returns the active users with the emails, it fails if any is unknown
+func (c *Client) UsersByEmail(emails []string) ([]slackgo.User, error) {
+	data := c.masterData()
+	users := make([]slackgo.User, 0, len(emails))
+	for _, email := range emails {
+		u, ok := data.userByEmail(email)
+		if !ok {
+			return nil, fmt.Errorf("slack: no active user with email '%s' found", email)
 		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// DisableGroup disables the group (found by handle) and updates the master data. The members are kept and returned
+// as unchanged, Disabled is false if the group was already disabled. In dry run nothing is written.
+func (c *Client) DisableGroup(ctx context.Context, groupHandle string, dryrun bool) (GroupChange, error) {
+	group, err := c.RefreshGroup(ctx, groupHandle)
+	if err != nil {
+		return GroupChange{}, fmt.Errorf("slack: retrieving slack group '%s' failed: %w", groupHandle, err)
 	}
-	return matchedSlackUsers
+	change := c.diffGroup(group, c.groupMembers(group))
+	if isDisabled(group) {
+		return change, nil
+	}
+	change.Disabled = true
+	if dryrun {
+		log.Infof("slack: dry run. would disable slack user group %s[%s]", group.Name, group.ID)
+		return change, nil
+	}
+	userGroup, err := c.user().DisableUserGroupContext(ctx, group.ID)
+	if err != nil {
+		return GroupChange{}, fmt.Errorf("slack: disabling user group %s[%s] failed: %w", group.Name, group.ID, err)
+	}
+	c.storeGroup(userGroup)
+	log.Infof("slack: disabled slack user group %s[%s]", userGroup.Name, userGroup.ID)
+	return change, nil
+}
+
+// groupMembers returns the members of the group
+func (c *Client) groupMembers(group slackgo.UserGroup) []slackgo.User {
+	members := make([]slackgo.User, 0, len(group.Users))
+	for _, id := range group.Users {
+		members = append(members, c.getUserByID(id))
+	}
+	return members
+}
+
+// isDisabled returns true if the group is disabled
+func isDisabled(group slackgo.UserGroup) bool {
+	return group.DateDelete != 0
 }
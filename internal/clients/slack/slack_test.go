@@ -1,9 +1,14 @@
 package slack
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -19,6 +24,18 @@ type slackTestData struct {
 	userGroups []slack.UserGroup
 	channels   []slack.Channel
 	users      []slack.User
+	pins       []slack.Item
+	pinned     int // number of pins.add calls
+
+	hookMutex      sync.Mutex
+	listGroupsHook func() // called before usergroups.list is answered, if set
+}
+
+// setListGroupsHook sets the function called before usergroups.list is answered
+func (sd *slackTestData) setListGroupsHook(hook func()) {
+	sd.hookMutex.Lock()
+	defer sd.hookMutex.Unlock()
+	sd.listGroupsHook = hook
 }
 
 type responseMetadata struct {
@@ -26,6 +43,11 @@ type responseMetadata struct {
 }
 
 func setup(t *testing.T) (*Client, *slacktest.Server) {
+	cut, testServer, _ := setupWithData(t)
+	return cut, testServer
+}
+
+func setupWithData(t *testing.T) (*Client, *slacktest.Server, *slackTestData) {
 	testServer := slacktest.NewTestServer()
 	go testServer.Start()
 
@@ -34,7 +56,7 @@ func setup(t *testing.T) (*Client, *slacktest.Server) {
 		createUserObject("glinda", "W07QCRPA4", "T0G9PQBBK"),
 	}
 
-	testData := slackTestData{
+	testData := &slackTestData{
 		users: users,
 		userGroups: []slack.UserGroup{
 			createUserGroupObject("S0614TZR7", "T060RNRCH", "Team Admins", "admins", users),
@@ -51,7 +73,11 @@ func setup(t *testing.T) (*Client, *slacktest.Server) {
 	testServer.Handle("/users.list", testData.createListUsersHandler)
 	testServer.Handle("/usergroups.list", testData.createListUserGroupsHandler)
 	testServer.Handle("/usergroups.disable", testData.createDisableUserGroupsHandler)
+	testServer.Handle("/usergroups.enable", testData.createEnableUserGroupsHandler)
 	testServer.Handle("/usergroups.users.update", testData.createUpdateUserGroupsUserHandler)
+	testServer.Handle("/usergroups.users.list", testData.createListUserGroupUsersHandler)
+	testServer.Handle("/pins.list", testData.createListPinsHandler)
+	testServer.Handle("/pins.add", testData.createAddPinHandler)
 
 	cfg := &config.SlackConfig{
 		UserSecurityToken: "TEST_TOKEN",
@@ -61,11 +87,11 @@ func setup(t *testing.T) (*Client, *slacktest.Server) {
 	var err error
 	apiURLOption := slack.OptionAPIURL(testServer.GetAPIURL())
 
-	botMock, err := newAPIClient(cfg.BotSecurityToken, apiURLOption)
+	botMock, err := newAPIClient(context.Background(), cfg.BotSecurityToken, apiURLOption)
 	if err != nil {
 		t.Fatalf("failed setting up test server: %s", err.Error())
 	}
-	userMock, err := newAPIClient(cfg.UserSecurityToken, apiURLOption)
+	userMock, err := newAPIClient(context.Background(), cfg.UserSecurityToken, apiURLOption)
 	if err != nil {
 		t.Fatalf("failed setting up test server: %s", err.Error())
 	}
@@ -76,11 +102,13 @@ func setup(t *testing.T) (*Client, *slacktest.Server) {
 		infoChannelID: cfg.InfoChannelID,
 	}
 
-	if err := client.LoadMasterData(); err != nil {
+	client.ctx, client.stop = context.WithCancel(context.Background())
+
+	if err := client.LoadMasterData(context.Background()); err != nil {
 		t.Fatalf("unexpected err loading masterdata: %s", err.Error())
 	}
 
-	return client, testServer
+	return client, testServer, testData
 }
 
 func TestGetSlackGroup(t *testing.T) {
@@ -97,26 +125,130 @@ func TestLoadSlackMasterData(t *testing.T) {
 	cut, testServer := setup(t)
 	defer testServer.Stop()
 
-	assert.NoError(t, cut.LoadMasterData())
-	assert.NotNil(t, cut.infoChannel)
-	assert.NotEmpty(t, cut.users)
-	assert.NotEmpty(t, cut.groups)
+	assert.NoError(t, cut.LoadMasterData(context.Background()))
+	data := cut.masterData()
+	assert.Contains(t, data.channelsByID, "1337")
+	assert.NotEmpty(t, data.usersByID)
+	assert.NotEmpty(t, data.groupsByHandle)
 }
 
 func TestGetSlackUser(t *testing.T) {
 	cut := Client{}
 	pdUsers := []pagerduty.User{{Email: "spengler@ghostbusters.example.com"}}
-	cut.users = []slack.User{
-		{Profile: slack.UserProfile{Email: "spengler@ghostbusters.example.com"}},
-		{Profile: slack.UserProfile{Email: "max@mustermann.example.com"}},
-	}
+	cut.data.Store(newMasterData(nil, []slack.User{
+		{ID: "W012A3CDE", Profile: slack.UserProfile{Email: "Spengler@ghostbusters.example.com"}},
+		{ID: "W07QCRPA4", Profile: slack.UserProfile{Email: "max@mustermann.example.com"}},
+		{ID: "W0DELETED", Profile: slack.UserProfile{Email: "spengler@ghostbusters.example.com"}, Deleted: true},
+	}, nil))
 
-	actualUsers, err := cut.MatchPDUsers(pdUsers)
+	actualUsers, err := cut.MatchPDUsers(context.Background(), pdUsers)
 
 	if assert.NoError(t, err) {
 		assert.Len(t, actualUsers, 1)
-		assert.Equal(t, "spengler@ghostbusters.example.com", actualUsers[0].Profile.Email)
+		assert.Equal(t, "W012A3CDE", actualUsers[0].ID)
+	}
+}
+
+func TestMatchStrategies(t *testing.T) {
+	data := newMasterData(nil, []slack.User{
+		{ID: "W1", RealName: "Egon Spengler", Profile: slack.UserProfile{Email: "egon.spengler@example.com"}},
+		{ID: "W2", RealName: "Ray Stantz", Profile: slack.UserProfile{Email: "ray@example.com"}},
+		{ID: "W3", RealName: "Peter Venkman", Profile: slack.UserProfile{Email: "pv@example.com"}},
+		{ID: "W4", RealName: "Winston Zeddemore", Profile: slack.UserProfile{Email: "wz@example.com"}},
+		{ID: "W5", RealName: "Janine Melnitz", Profile: slack.UserProfile{Email: "janine@example.com"}},
+		{ID: "W6", RealName: "Slimer", Profile: slack.UserProfile{Email: "slimer@example.com"}},
+		{ID: "W7", RealName: "Slimer", Profile: slack.UserProfile{Email: "slimer@ghostbusters.example.org"}},
+	}, nil)
+	matching := config.UserMatching{
+		Strategies:     []config.MatchStrategy{config.MatchEmail, config.MatchEmailAlias, config.MatchMappingFile, config.MatchProfileField, config.MatchName},
+		DomainAliases:  map[string]string{"ghostbusters.example.org": "example.com", "gb.example.org": "example.com"},
+		NameSimilarity: 0.9,
+	}
+	data.mappedIDs = map[string]string{"P3": "W3"}
+	data.profileIDs = map[string]string{"P4": "W4"}
+	data.usersByAlias = indexByCanonicalEmail(data.usersByEmail, matching.DomainAliases)
+	matchers := newMatchers(matching)
+
+	matches := data.matchPDToSlackUsers([]pagerduty.User{
+		{APIObject: pagerduty.APIObject{ID: "P1"}, Email: "Egon.Spengler@example.com"},
+		{APIObject: pagerduty.APIObject{ID: "P2"}, Email: "ray@ghostbusters.example.org"},
+		{APIObject: pagerduty.APIObject{ID: "P3"}, Email: "peter@example.net"},
+		{APIObject: pagerduty.APIObject{ID: "P4"}},
+		{APIObject: pagerduty.APIObject{ID: "P5"}, Name: "Melnitz, Janine"},
+		{APIObject: pagerduty.APIObject{ID: "P6"}, Name: "Louis Tully", Email: "louis@example.com"},
+		// both slack users resolve to slimer@example.com
+		{APIObject: pagerduty.APIObject{ID: "P7"}, Email: "slimer@gb.example.org"},
+	}, matchers)
+
+	var actual []string
+	for _, m := range matches {
+		actual = append(actual, fmt.Sprintf("%s=%s by %s", m.PDUser.ID, m.SlackUser.ID, m.Strategy))
+	}
+	assert.Equal(t, []string{"P1=W1 by email", "P2=W2 by emailAlias", "P3=W3 by mappingFile", "P4=W4 by profileField", "P5=W5 by name"}, actual)
+	assert.Equal(t, "PABC123", pdIDFromField("https://example.pagerduty.com/users/PABC123/"))
+}
+
+func TestLoadProfileIDs(t *testing.T) {
+	cut, testServer := setup(t)
+	defer testServer.Stop()
+
+	var mutex sync.Mutex
+	requests := map[string]int{}
+	testServer.Handle("/users.profile.get", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		user := r.FormValue("user")
+		mutex.Lock()
+		requests[user]++
+		mutex.Unlock()
+		if user != "W012A3CDE" {
+			_, _ = w.Write([]byte(`{"ok":false,"error":"user_not_found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"profile":{"fields":{"Xf01":{"value":"https://example.pagerduty.com/users/PSPENGLER/"}}}}`))
+	})
+	cut.matching = config.UserMatching{Strategies: []config.MatchStrategy{config.MatchProfileField}, ProfileFieldID: "Xf01"}
+
+	loaded := func() bool {
+		return !cut.profileLoading.Load() && cut.masterData().profileIDs["PSPENGLER"] == "W012A3CDE"
 	}
+	// the failed profile is skipped, the master data is available at once
+	assert.NoError(t, cut.LoadMasterData(context.Background()))
+	assert.NotNil(t, cut.masterData())
+	assert.Eventually(t, loaded, time.Second, 10*time.Millisecond)
+
+	// only the failed profile is requested again
+	assert.NoError(t, cut.LoadMasterData(context.Background()))
+	assert.Equal(t, "W012A3CDE", cut.masterData().profileIDs["PSPENGLER"])
+	assert.Eventually(t, loaded, time.Second, 10*time.Millisecond)
+	mutex.Lock()
+	assert.Equal(t, map[string]int{"W012A3CDE": 1, "W07QCRPA4": 2}, requests)
+	mutex.Unlock()
+
+	// expired profiles are requested again
+	cut.profileMutex.Lock()
+	cut.profilePDIDs["W012A3CDE"] = profileField{pdID: "PSPENGLER", loadedAt: time.Now().Add(-profileTTL)}
+	cut.profileMutex.Unlock()
+	_, missing := cut.profileIDs(cut.masterData().usersByID)
+	assert.Len(t, missing, 2)
+}
+
+func TestLoadProfileIDsStopped(t *testing.T) {
+	cut, testServer := setup(t)
+	defer testServer.Stop()
+
+	var requests int32
+	testServer.Handle("/users.profile.get", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte(`{"ok":true,"profile":{"fields":{}}}`))
+	})
+	cut.matching = config.UserMatching{Strategies: []config.MatchStrategy{config.MatchProfileField}, ProfileFieldID: "Xf01"}
+	cut.Close()
+
+	assert.NoError(t, cut.LoadMasterData(context.Background()))
+	assert.Eventually(t, func() bool { return !cut.profileLoading.Load() }, time.Second, 10*time.Millisecond)
+	assert.Zero(t, atomic.LoadInt32(&requests), "no profiles requested after shutdown")
+	_, missing := cut.profileIDs(cut.masterData().usersByID)
+	assert.Len(t, missing, 2, "requested again with the next load")
 }
 
 func TestSetSlackUserGroup(t *testing.T) {
@@ -124,32 +256,343 @@ func TestSetSlackUserGroup(t *testing.T) {
 	defer testServer.Stop()
 
 	slackUsers := []slack.User{{ID: "W012A3CDE"}}
-	noChange, err := cut.AddToGroup("admins", slackUsers, false)
+	change, err := cut.AddToGroup(context.Background(), "admins", slackUsers, false)
 
-	assert.NoError(t, err)
-	assert.False(t, noChange)
+	if assert.NoError(t, err) {
+		assert.Empty(t, change.Added)
+		assert.Equal(t, []slack.User{{ID: "W012A3CDE"}}, change.Unchanged)
+		if assert.Len(t, change.Removed, 1) {
+			assert.Equal(t, "glinda", change.Removed[0].Name)
+		}
+	}
+
+	group, err := cut.GetSlackGroup("admins")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"W012A3CDE"}, group.Users)
+		assert.Equal(t, 1, group.UserCount)
+	}
+}
+
+func TestRefreshSlackGroup(t *testing.T) {
+	cut, testServer := setup(t)
+	defer testServer.Stop()
+
+	stale := cut.masterData().groupsByHandle["admins"]
+	stale.Users, stale.UserCount = nil, 0
+	cut.data.Store(cut.masterData().withGroup(stale, 0))
+
+	group, err := cut.RefreshGroup(context.Background(), "admins")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"W012A3CDE", "W07QCRPA4"}, group.Users)
+	}
+	cached, err := cut.GetSlackGroup("admins")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, cached.UserCount)
+	}
 }
 
 func TestSetSlackUserGroupNoChange(t *testing.T) {
 	cut, testServer := setup(t)
 	defer testServer.Stop()
 
+	// order differs from the group and a user is on call in two schedules
 	slackUsers := []slack.User{
+		{ID: "W07QCRPA4"},
 		{ID: "W012A3CDE"},
 		{ID: "W07QCRPA4"},
 	}
-	noChange, err := cut.AddToGroup("admins", slackUsers, false)
+	change, err := cut.AddToGroup(context.Background(), "admins", slackUsers, false)
 
-	assert.NoError(t, err)
-	assert.True(t, noChange)
+	if assert.NoError(t, err) {
+		assert.False(t, change.HasChanges())
+		assert.Len(t, change.Unchanged, 2)
+	}
+}
+
+func TestSetSlackUserGroupDryRun(t *testing.T) {
+	cut, testServer := setup(t)
+	defer testServer.Stop()
+
+	slackUsers := []slack.User{{ID: "W0NEWUSER"}, {ID: "W012A3CDE"}}
+	change, err := cut.AddToGroup(context.Background(), "owners", slackUsers, true)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, slackUsers, change.Added)
+		assert.Empty(t, change.Removed)
+	}
+	group, err := cut.GetSlackGroup("owners")
+	if assert.NoError(t, err) {
+		assert.Empty(t, group.Users)
+	}
+}
+
+func TestDiffSlackGroup(t *testing.T) {
+	cut, testServer := setup(t)
+	defer testServer.Stop()
+
+	slackUsers := []slack.User{
+		{ID: "W012A3CDE"},
+		{ID: "W0NEWUSER"},
+		{ID: "W0NEWUSER"},
+	}
+	change, err := cut.DiffGroup("admins", slackUsers)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, []slack.User{{ID: "W0NEWUSER"}}, change.Added)
+		assert.Equal(t, []slack.User{{ID: "W012A3CDE"}}, change.Unchanged)
+		if assert.Len(t, change.Removed, 1) {
+			assert.Equal(t, "glinda", change.Removed[0].Name)
+		}
+	}
 }
 
 func TestDisableSlackGroup(t *testing.T) {
 	cut, testServer := setup(t)
 	defer testServer.Stop()
 
-	err := cut.DisableGroup("S0615G0KT")
+	change, err := cut.DisableGroup(context.Background(), "admins", false)
+	if assert.NoError(t, err) {
+		assert.True(t, change.Disabled)
+		assert.Len(t, change.Unchanged, 2)
+	}
+
+	// already disabled
+	change, err = cut.DisableGroup(context.Background(), "admins", false)
+	if assert.NoError(t, err) {
+		assert.False(t, change.Disabled)
+	}
+}
+
+func TestSetSlackUserGroupEnablesGroup(t *testing.T) {
+	cut, testServer := setup(t)
+	defer testServer.Stop()
+
+	_, err := cut.DisableGroup(context.Background(), "admins", false)
+	assert.NoError(t, err)
+
+	slackUsers := []slack.User{{ID: "W012A3CDE"}, {ID: "W07QCRPA4"}}
+	change, err := cut.AddToGroup(context.Background(), "admins", slackUsers, false)
+	if assert.NoError(t, err) {
+		assert.True(t, change.Enabled)
+		assert.True(t, change.HasChanges())
+	}
+	group, err := cut.GetSlackGroup("admins")
+	if assert.NoError(t, err) {
+		assert.Zero(t, group.DateDelete)
+	}
+}
+
+func TestKeepSlackGroup(t *testing.T) {
+	cut, testServer := setup(t)
+	defer testServer.Stop()
+
+	change, err := cut.KeepGroup(context.Background(), "admins")
+	if assert.NoError(t, err) {
+		assert.False(t, change.HasChanges())
+		if assert.Len(t, change.Unchanged, 2) {
+			assert.Equal(t, "spengler", change.Unchanged[0].Name)
+		}
+	}
+}
+
+func TestMatchEmptyPDUsers(t *testing.T) {
+	cut := Client{}
+
+	users, err := cut.MatchPDUsers(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func TestPostMessageTo(t *testing.T) {
+	cut, testServer := setup(t)
+	defer testServer.Stop()
+
+	for _, channel := range []string{"", "123", "#test", "Test"} {
+		_, err := cut.PostMessageTo(context.Background(), channel, slack.MsgOptionText("hello", false))
+		assert.NoError(t, err, channel)
+	}
+	_, err := cut.PostMessageTo(context.Background(), "unknown", slack.MsgOptionText("hello", false))
+	assert.Error(t, err)
+}
+
+func TestSendDirectMessage(t *testing.T) {
+	cut, testServer := setup(t)
+	defer testServer.Stop()
+	cut.dmInterval = time.Hour
+
+	sent, err := cut.SendDirectMessage(context.Background(), "W012A3CDE", "missing-phone", slack.MsgOptionText("hello", false))
+	assert.NoError(t, err)
+	assert.True(t, sent)
+
+	// rate limited per user and topic
+	sent, err = cut.SendDirectMessage(context.Background(), "W012A3CDE", "missing-phone", slack.MsgOptionText("hello", false))
+	assert.NoError(t, err)
+	assert.False(t, sent)
+	sent, err = cut.SendDirectMessage(context.Background(), "W012A3CDE", "other", slack.MsgOptionText("hello", false))
+	assert.NoError(t, err)
+	assert.True(t, sent)
+}
+
+func TestSendDirectMessageConcurrent(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var failures int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("channel") {
+		case "W1":
+			close(started)
+			<-release
+		case "W3":
+			if atomic.AddInt32(&failures, 1) == 1 {
+				_, _ = w.Write([]byte(`{"ok": false, "error": "user_not_found"}`))
+				return
+			}
+		}
+		_, _ = w.Write([]byte(`{"ok": true, "channel": "D1", "ts": "1700000000.000100"}`))
+	}))
+	defer server.Close()
+	cut := &Client{botClient: slack.New("TEST_TOKEN", slack.OptionAPIURL(server.URL+"/")), dmInterval: time.Hour}
+	send := func(userID string) (bool, error) {
+		return cut.SendDirectMessage(context.Background(), userID, "missing-phone", slack.MsgOptionText("hello", false))
+	}
+
+	result := make(chan bool)
+	go func() {
+		sent, err := send("W1")
+		assert.NoError(t, err)
+		result <- sent
+	}()
+	<-started
+
+	// not blocked by the message in flight, which is reserved
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sent, err := send("W2")
+		assert.NoError(t, err)
+		assert.True(t, sent)
+		sent, err = send("W1")
+		assert.NoError(t, err)
+		assert.False(t, sent)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("direct messages are serialized")
+	}
+	close(release)
+	assert.True(t, <-result)
+
+	// the reservation of a failed message is rolled back
+	_, err := send("W3")
+	assert.Error(t, err)
+	sent, err := send("W3")
 	assert.NoError(t, err)
+	assert.True(t, sent)
+}
+
+func TestThreadParent(t *testing.T) {
+	cut, testServer, testData := setupWithData(t)
+	defer testServer.Stop()
+
+	// pinned before a restart
+	pinned := slack.Message{}
+	pinned.Text = "history of `oncall` &gt; L1"
+	pinned.Timestamp = "1700000000.000100"
+	testData.pins = []slack.Item{{Type: "message", Channel: "123", Message: &pinned}}
+
+	ts, err := cut.ThreadParent(context.Background(), "test", "history of `oncall` > L1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1700000000.000100", ts)
+	}
+
+	ts, err = cut.ThreadParent(context.Background(), "test", "history of `admins`")
+	if assert.NoError(t, err) {
+		assert.NotEmpty(t, ts)
+		assert.Equal(t, 1, testData.pinned)
+	}
+	again, err := cut.ThreadParent(context.Background(), "test", "history of `admins`")
+	if assert.NoError(t, err) {
+		assert.Equal(t, ts, again)
+		assert.Equal(t, 1, testData.pinned, "parent is cached")
+	}
+}
+
+func TestMasterDataConcurrentReload(t *testing.T) {
+	cut, testServer := setup(t)
+	defer testServer.Stop()
+
+	pdUsers := []pagerduty.User{{Email: "spengler@ghostbusters.example.com"}, {Email: "glinda@ghostbusters.example.com"}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, cut.LoadMasterData(context.Background()))
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := cut.GetSlackGroup("admins")
+				assert.NoError(t, err)
+				users, err := cut.MatchPDUsers(context.Background(), pdUsers)
+				assert.NoError(t, err)
+				assert.Len(t, users, 2)
+				_, err = cut.DiffGroup("admins", users)
+				assert.NoError(t, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				_, err := cut.AddToGroup(context.Background(), "owners", []slack.User{{ID: "W012A3CDE"}}, false)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	group, err := cut.GetSlackGroup("owners")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "owners", group.Handle)
+	}
+}
+
+func TestMasterDataReloadKeepsNewerGroups(t *testing.T) {
+	cut, testServer, testData := setupWithData(t)
+	defer testServer.Stop()
+
+	listed, release := make(chan struct{}), make(chan struct{})
+	testData.setListGroupsHook(func() {
+		close(listed)
+		<-release
+	})
+	reloaded := make(chan error)
+	go func() {
+		reloaded <- cut.LoadMasterData(context.Background())
+	}()
+
+	// the group is written after the reload listed the groups, the listed ones are stale
+	<-listed
+	testData.setListGroupsHook(nil)
+	change, err := cut.AddToGroup(context.Background(), "admins", []slack.User{{ID: "W012A3CDE"}}, false)
+	if assert.NoError(t, err) {
+		assert.Len(t, change.Removed, 1)
+	}
+	close(release)
+	assert.NoError(t, <-reloaded)
+
+	group, err := cut.GetSlackGroup("admins")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"W012A3CDE"}, group.Users)
+	}
+
+	// a reload started after the write takes the listed groups
+	assert.NoError(t, cut.LoadMasterData(context.Background()))
+	group, err = cut.GetSlackGroup("admins")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"W012A3CDE", "W07QCRPA4"}, group.Users)
+	}
 }
 
 func (sd *slackTestData) createListConversationsHandler(w http.ResponseWriter, r *http.Request) {
@@ -189,6 +632,12 @@ func (sd *slackTestData) createListUsersHandler(w http.ResponseWriter, r *http.R
 }
 
 func (sd *slackTestData) createListUserGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	sd.hookMutex.Lock()
+	hook := sd.listGroupsHook
+	sd.hookMutex.Unlock()
+	if hook != nil {
+		hook()
+	}
 	userGroupsResponse := struct {
 		UserGroups       []slack.UserGroup `json:"usergroups"`
 		ResponseMetadata responseMetadata  `json:"response_metadata"`
@@ -203,7 +652,16 @@ func (sd *slackTestData) createListUserGroupsHandler(w http.ResponseWriter, r *h
 }
 
 func (sd *slackTestData) createDisableUserGroupsHandler(w http.ResponseWriter, r *http.Request) {
-	ug := r.Form.Get("usergroup")
+	sd.setUserGroupDeleted(w, r, slack.JSONTime(time.Now().Unix()))
+}
+
+func (sd *slackTestData) createEnableUserGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	sd.setUserGroupDeleted(w, r, 0)
+}
+
+// setUserGroupDeleted answers usergroups.disable and usergroups.enable with the group having the delete date
+func (sd *slackTestData) setUserGroupDeleted(w http.ResponseWriter, r *http.Request, dateDelete slack.JSONTime) {
+	ug := r.FormValue("usergroup")
 	userGroupResponse := struct {
 		UserGroup slack.UserGroup `json:"usergroup"`
 		slack.SlackResponse
@@ -214,10 +672,8 @@ func (sd *slackTestData) createDisableUserGroupsHandler(w http.ResponseWriter, r
 	for _, g := range sd.userGroups {
 		if g.ID == ug {
 			userGroupResponse.Ok = true
+			g.DateDelete = dateDelete
 			userGroupResponse.UserGroup = g
-			g.DateDelete = slack.JSONTime(time.Now().Unix())
-			g.Users = []string{}
-			g.UserCount = 0
 		}
 	}
 	if err := json.NewEncoder(w).Encode(userGroupResponse); err != nil {
@@ -241,23 +697,10 @@ func (sd *slackTestData) createUpdateUserGroupsUserHandler(w http.ResponseWriter
 
 	users := strings.Split(r.Form.Get("users"), ",")
 
-	containsUser := func(userGroup slack.UserGroup, username string) bool {
-		for _, u := range userGroup.Users {
-			if u == username {
-				return true
-			}
-		}
-		return false
-	}
-
 	for _, g := range sd.userGroups {
 		if g.ID == r.Form.Get("usergroup") {
-			for _, u := range users {
-				if !containsUser(g, u) {
-					g.Users = append(g.Users, u)
-					g.UserCount = len(g.Users)
-				}
-			}
+			g.Users = users
+			g.UserCount = len(g.Users)
 			updateUserGroupResponse.UserGroup = g
 		}
 	}
@@ -268,6 +711,47 @@ func (sd *slackTestData) createUpdateUserGroupsUserHandler(w http.ResponseWriter
 	}
 }
 
+func (sd *slackTestData) createListUserGroupUsersHandler(w http.ResponseWriter, r *http.Request) {
+	usersResponse := struct {
+		Users []string `json:"users"`
+		slack.SlackResponse
+	}{}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, g := range sd.userGroups {
+		if g.ID == r.Form.Get("usergroup") {
+			usersResponse.Ok = true
+			usersResponse.Users = g.Users
+		}
+	}
+	if !usersResponse.Ok {
+		usersResponse.Error = "no_such_subteam"
+	}
+	if err := json.NewEncoder(w).Encode(usersResponse); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (sd *slackTestData) createListPinsHandler(w http.ResponseWriter, r *http.Request) {
+	pinsResponse := struct {
+		Items []slack.Item `json:"items"`
+		slack.SlackResponse
+	}{Items: sd.pins}
+	pinsResponse.Ok = true
+	if err := json.NewEncoder(w).Encode(pinsResponse); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (sd *slackTestData) createAddPinHandler(w http.ResponseWriter, r *http.Request) {
+	sd.pinned++
+	if err := json.NewEncoder(w).Encode(slack.SlackResponse{Ok: true}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 func createChannelObject(name, id string) slack.Channel {
 	return slack.Channel{
 		IsChannel: true,
@@ -282,9 +766,10 @@ func createChannelObject(name, id string) slack.Channel {
 
 func createUserObject(name, id, teamID string) slack.User {
 	return slack.User{
-		ID:     id,
-		Name:   name,
-		TeamID: teamID,
+		ID:      id,
+		Name:    name,
+		TeamID:  teamID,
+		Profile: slack.UserProfile{Email: name + "@ghostbusters.example.com"},
 	}
 }
 
@@ -19,8 +19,8 @@ type Bot struct {
 
 func NewEventBot(c *Client) (*Bot, error) {
 	b := &Bot{
-		client:    c.botClient,
-		rtmClient: c.botClient.NewRTM(),
+		client:    c.bot(),
+		rtmClient: c.bot().NewRTM(),
 		//botID:      cfg.BotID,
 	}
 
@@ -1,11 +1,13 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"time"
 
-	yaml "gopkg.in/yaml.v2"
+	yaml "gopkg.in/yaml.v3"
 )
 
 // Config we need
@@ -14,7 +16,14 @@ type Config struct {
 	Pagerduty      PagerdutyConfig `yaml:"pagerduty"`
 	Global         GlobalConfig    `yaml:"global"`
 	Jobs           JobsConfig      `yaml:"jobs"`
-	ConfigFilePath string
+	ConfigFilePath string          `yaml:"-"`
+	lines          map[string]int  // of the keys in the YAML file by path
+}
+
+// Line returns the line of the key in the YAML file, e.g. of `jobs.pd-teams-to-slack-group[0].syncObjects`,
+// 0 if the key is not set or the config wasn't read from a file
+func (c *Config) Line(path string) int {
+	return c.lines[path]
 }
 
 // GlobalConfig Options passed via cmd line
@@ -25,48 +34,150 @@ type GlobalConfig struct {
 	// write
 	Write bool `yaml:"write"`
 
-	RecheckInterval time.Duration
 	// if true all task run at start
 	RunAtStart bool `yaml:"runAtStart"`
+
+	// address the metrics and health endpoints listen on
+	ListenAddress string `yaml:"listenAddress"`
+
+	// how long loading the slack master data may fail until the service is not ready anymore
+	MasterDataMaxFailureDuration time.Duration `yaml:"masterDataMaxFailureDuration"`
+
+	// how often the config file is checked for changes to reload the jobs
+	ConfigReloadInterval time.Duration `yaml:"configReloadInterval"`
+
+	// default time zone of the job cron expressions
+	Timezone Timezone `yaml:"timezone"`
+
+	// retries of rate limited or failed api requests
+	Retry RetryConfig `yaml:"retry"`
+
+	// after which a job run including retries of api requests is cancelled
+	JobDeadline time.Duration `yaml:"jobDeadline"`
+
+	// when info messages are posted, default of the jobs
+	Notify NotifyPolicy `yaml:"notify"`
+
+	// when the digest of runs without info message is posted, e.g. daily; disabled if empty
+	DigestCrontabExpression string `yaml:"digestCrontabExpression"`
+}
+
+// RetryConfig of rate limited or failed api requests
+type RetryConfig struct {
+	// attempts including the first request
+	MaxAttempts int `yaml:"maxAttempts"`
+	// delay before the first retry, doubled for every further retry
+	BaseDelay time.Duration `yaml:"baseDelay"`
+	// upper bound of the delay between retries
+	MaxDelay time.Duration `yaml:"maxDelay"`
 }
 
 // JobsConfig Real Work Definition
 type JobsConfig struct {
-	ScheduleSync []PagerdutyScheduleOnDutyToSlackGroup `yaml:"pd-schedules-on-duty-to-slack-group"`
-	TeamSync     []PagerdutyTeamToSlackGroup           `yaml:"pd-teams-to-slack-group"`
+	ScheduleSync []PagerdutyScheduleOnDutyToSlackGroup   `yaml:"pd-schedules-on-duty-to-slack-group"`
+	TeamSync     []PagerdutyTeamToSlackGroup             `yaml:"pd-teams-to-slack-group"`
+	PolicySync   []PagerdutyEscalationPolicyToSlackGroup `yaml:"pd-escalation-policies-to-slack-group"`
 }
 
 // SlackConfig Struct
 type SlackConfig struct {
 	// Token to authenticate
-	BotSecurityToken  string
-	UserSecurityToken string
-	InfoChannelID     string `yaml:"infoChannelID"`
-	Workspace         string `yaml:"workspaceForChatLinks"`
+	BotSecurityToken  string `yaml:"-"`
+	UserSecurityToken string `yaml:"-"`
+	// files to read the tokens from, take precedence over env variables
+	BotSecurityTokenFile  string `yaml:"botTokenFile"`
+	UserSecurityTokenFile string `yaml:"userTokenFile"`
+	InfoChannelID         string `yaml:"infoChannelID"`
+	Workspace             string `yaml:"workspaceForChatLinks"`
+	// how often the same direct message is sent to a user at most
+	DirectMessageInterval time.Duration `yaml:"directMessageInterval"`
+	// how pagerduty users are matched to slack users
+	UserMatching UserMatching `yaml:"userMatching"`
+	// url of the slack web api, only set for proxies and tests
+	APIURL string `yaml:"apiURL"`
+}
+
+// MatchStrategy matches a pagerduty user to a slack user
+type MatchStrategy string
+
+const (
+	MatchEmail        MatchStrategy = "email"        // same email, case-insensitive
+	MatchEmailAlias   MatchStrategy = "emailAlias"   // same email local part, domains resolved by domainAliases
+	MatchMappingFile  MatchStrategy = "mappingFile"  // slack user ID by pagerduty user ID in the mapping file
+	MatchProfileField MatchStrategy = "profileField" // pagerduty user ID in a custom field of the slack profile
+	MatchName         MatchStrategy = "name"         // similar name, last resort
+)
+
+// UnmarshalYAML accepts only known match strategies
+func (m *MatchStrategy) UnmarshalYAML(node *yaml.Node) error {
+	switch strategy := MatchStrategy(node.Value); strategy {
+	case MatchEmail, MatchEmailAlias, MatchMappingFile, MatchProfileField, MatchName:
+		*m = strategy
+	default:
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: unknown match strategy '%s', use one of %s, %s, %s, %s, %s",
+			node.Line, node.Value, MatchEmail, MatchEmailAlias, MatchMappingFile, MatchProfileField, MatchName)}}
+	}
+	return nil
+}
+
+// UserMatching configures the strategies matching pagerduty users to slack users
+type UserMatching struct {
+	// tried in order until one matches, default is email
+	Strategies []MatchStrategy `yaml:"strategies"`
+	// canonical email domain by alias domain, e.g. "example.org": "example.com"
+	DomainAliases map[string]string `yaml:"domainAliases"`
+	// yaml file with slack user IDs by pagerduty user ID
+	MappingFile string `yaml:"mappingFile"`
+	// ID of the custom slack profile field containing the pagerduty user ID
+	ProfileFieldID string `yaml:"profileFieldID"`
+	// minimum similarity of the names between 0 and 1, default is 0.9
+	NameSimilarity float64 `yaml:"nameSimilarity"`
+}
+
+// Uses returns true if the strategy is configured
+func (m UserMatching) Uses(strategy MatchStrategy) bool {
+	for _, s := range m.Strategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
 }
 
 // PagerdutyConfig Struct
 type PagerdutyConfig struct {
 	// Token to authenticate
-	AuthToken string
-	APIUser   string
+	AuthToken string `yaml:"-"`
+	APIUser   string `yaml:"-"`
+	// files to read the token and user from, take precedence over env variables
+	AuthTokenFile string `yaml:"authTokenFile"`
+	APIUserFile   string `yaml:"apiUserFile"`
+	// how long users are cached before they are requested again
+	UserCacheTTL time.Duration `yaml:"userCacheTTL"`
+	// url of the pagerduty rest api, only set for proxies and tests
+	APIEndpoint string `yaml:"apiEndpoint"`
 }
 
 // PagerdutyScheduleOnDutyToSlackGroup Struct
 type PagerdutyScheduleOnDutyToSlackGroup struct {
-	CrontabExpressionForRepetition string              `yaml:"crontabExpressionForRepetition"`
-	DisableHandleIfNoneOnShift     bool                `yaml:"disableSlackHandleTemporaryIfNoneOnShift"`
-	CheckUserContactForPhoneSet    bool                `yaml:"informUserIfContactPhoneNumberMissing"`
-	SyncOptions                    ScheduleSyncOptions `yaml:"syncOptions"`
-	ObjectsToSync                  SyncObjects         `yaml:"syncObjects"`
+	CrontabExpressionForRepetition string               `yaml:"crontabExpressionForRepetition"`
+	Timezone                       Timezone             `yaml:"timezone"`
+	Notify                         NotifyPolicy         `yaml:"notify"`
+	InfoMessage                    InfoMessageOptions   `yaml:",inline"`
+	EmptyRotation                  EmptyRotationOptions `yaml:",inline"`
+	DisableHandleIfNoneOnShift     bool                 `yaml:"disableSlackHandleTemporaryIfNoneOnShift"` // deprecated, use emptyRotation
+	CheckUserContactForPhoneSet    bool                 `yaml:"informUserIfContactPhoneNumberMissing"`
+	InformManagerIfUnmatched       bool                 `yaml:"informTeamManagerIfUserUnmatched"`
+	SyncOptions                    ScheduleSyncOptions  `yaml:"syncOptions"`
+	ObjectsToSync                  SyncObjects          `yaml:"syncObjects"`
 }
 
 // ScheduleSyncOptions SyncOptions Struct
 type ScheduleSyncOptions struct {
-	HandoverTimeFrameForward                 string `yaml:"handoverTimeFrameForward"`
-	HandoverTimeFrameBackward                string `yaml:"handoverTimeFrameBackward"`
-	DisableSlackHandleTemporaryIfNoneOnShift bool   `yaml:"disableSlackHandleTemporaryIfNoneOnShift"`
-	InformUserIfContactPhoneNumberMissing    bool   `yaml:"informUserIfContactPhoneNumberMissing"`
+	HandoverTimeFrameForward                 time.Duration `yaml:"handoverTimeFrameForward"`
+	HandoverTimeFrameBackward                time.Duration `yaml:"handoverTimeFrameBackward"`
+	DisableSlackHandleTemporaryIfNoneOnShift bool          `yaml:"disableSlackHandleTemporaryIfNoneOnShift"` // deprecated, use emptyRotation
+	InformUserIfContactPhoneNumberMissing    bool          `yaml:"informUserIfContactPhoneNumberMissing"`
 	//TakeTheLayersNotTheFinal bool `yaml:"scheduleLayerFinalOnly"`
 	SyncStyle SyncStyle `yaml:"syncStyle"`
 }
@@ -75,16 +186,152 @@ type ScheduleSyncOptions struct {
 type SyncStyle string
 
 const (
-	FinalLayer           = "FinalLayer"
-	OverridesOnlyIfThere = "OverridesOnlyIfThere"
-	AllActiveLayers      = "AllActiveLayers"
+	FinalLayer           SyncStyle = "FinalLayer"
+	OverridesOnlyIfThere SyncStyle = "OverridesOnlyIfThere"
+	AllActiveLayers      SyncStyle = "AllActiveLayers"
 )
 
+// UnmarshalYAML accepts only known sync styles, empty defaults to AllActiveLayers
+func (s *SyncStyle) UnmarshalYAML(node *yaml.Node) error {
+	switch style := SyncStyle(node.Value); style {
+	case FinalLayer, OverridesOnlyIfThere, AllActiveLayers:
+		*s = style
+	case "":
+		*s = AllActiveLayers
+	default:
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: unknown sync style '%s', use one of %s, %s, %s",
+			node.Line, node.Value, FinalLayer, OverridesOnlyIfThere, AllActiveLayers)}}
+	}
+	return nil
+}
+
+// NotifyPolicy decides after which runs of a job the info message is posted
+type NotifyPolicy string
+
+const (
+	NotifyAlways   NotifyPolicy = "always"   // after every run
+	NotifyOnChange NotifyPolicy = "onChange" // if the slack group changed or the run failed
+	NotifyOnError  NotifyPolicy = "onError"  // if the run failed
+	NotifyNever    NotifyPolicy = "never"    // never
+)
+
+// UnmarshalYAML accepts only known notify policies
+func (n *NotifyPolicy) UnmarshalYAML(node *yaml.Node) error {
+	switch policy := NotifyPolicy(node.Value); policy {
+	case NotifyAlways, NotifyOnChange, NotifyOnError, NotifyNever, "":
+		*n = policy
+	default:
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: unknown notify policy '%s', use one of %s, %s, %s, %s",
+			node.Line, node.Value, NotifyAlways, NotifyOnChange, NotifyOnError, NotifyNever)}}
+	}
+	return nil
+}
+
+// EmptyRotation decides what happens to the slack group when nobody is on shift
+type EmptyRotation string
+
+const (
+	EmptyRotationKeep     EmptyRotation = "keep"     // keep the members of the last run
+	EmptyRotationDisable  EmptyRotation = "disable"  // disable the group until somebody is on shift again
+	EmptyRotationFallback EmptyRotation = "fallback" // set the fallback members until somebody is on shift again
+)
+
+// UnmarshalYAML accepts only known empty rotation behaviors
+func (e *EmptyRotation) UnmarshalYAML(node *yaml.Node) error {
+	switch behavior := EmptyRotation(node.Value); behavior {
+	case EmptyRotationKeep, EmptyRotationDisable, EmptyRotationFallback, "":
+		*e = behavior
+	default:
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: unknown empty rotation behavior '%s', use one of %s, %s, %s",
+			node.Line, node.Value, EmptyRotationKeep, EmptyRotationDisable, EmptyRotationFallback)}}
+	}
+	return nil
+}
+
+// EmptyRotationOptions of a job
+type EmptyRotationOptions struct {
+	// what happens to the slack group if nobody is on shift, default is fallback if configured or keep
+	Behavior EmptyRotation `yaml:"emptyRotation"`
+	// members set to the slack group if nobody is on shift
+	Fallback Fallback `yaml:"fallback"`
+}
+
+// Fallback members of a slack group, all given users are combined
+type Fallback struct {
+	SlackUserIDs       []string `yaml:"slackUserIds"`
+	Emails             []string `yaml:"emails"`               // of slack users
+	SlackGroupHandle   string   `yaml:"slackGroupHandle"`     // members of another slack group
+	EscalationPolicyID string   `yaml:"pdEscalationPolicyId"` // users on call for a pagerduty escalation policy
+}
+
+// IsEmpty returns true if no fallback is configured
+func (f Fallback) IsEmpty() bool {
+	return len(f.SlackUserIDs) == 0 && len(f.Emails) == 0 && f.SlackGroupHandle == "" && f.EscalationPolicyID == ""
+}
+
+// setDefault sets the behavior to fallback if configured, else to the given one
+func (o *EmptyRotationOptions) setDefault(behavior EmptyRotation) {
+	switch {
+	case o.Behavior != "":
+	case !o.Fallback.IsEmpty():
+		o.Behavior = EmptyRotationFallback
+	default:
+		o.Behavior = behavior
+	}
+}
+
+// InfoMessageOptions of a job
+type InfoMessageOptions struct {
+	// channel ID or name the info messages are posted to, default is slack.infoChannelID
+	Channel string `yaml:"infoChannel"`
+	// post the info messages as replies to a message of the job pinned to the channel
+	Thread bool `yaml:"infoThread"`
+}
+
+// Timezone is the IANA name of a time zone, e.g. `Europe/Berlin`
+type Timezone string
+
+// UnmarshalYAML accepts only time zones known to the system
+func (t *Timezone) UnmarshalYAML(node *yaml.Node) error {
+	if _, err := time.LoadLocation(node.Value); err != nil {
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: unknown time zone '%s'", node.Line, node.Value)}}
+	}
+	*t = Timezone(node.Value)
+	return nil
+}
+
+// Location returns the time zone as location, UTC if not set
+func (t Timezone) Location() *time.Location {
+	loc, err := time.LoadLocation(string(t))
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
 // PagerdutyTeamToSlackGroup Struct
 type PagerdutyTeamToSlackGroup struct {
-	CrontabExpressionForRepetition string      `yaml:"crontabExpressionForRepetition"`
-	CheckUserContactForPhoneSet    bool        `yaml:"informUserIfContactPhoneNumberMissing"`
-	ObjectsToSync                  SyncObjects `yaml:"syncObjects"`
+	CrontabExpressionForRepetition string               `yaml:"crontabExpressionForRepetition"`
+	Timezone                       Timezone             `yaml:"timezone"`
+	Notify                         NotifyPolicy         `yaml:"notify"`
+	InfoMessage                    InfoMessageOptions   `yaml:",inline"`
+	EmptyRotation                  EmptyRotationOptions `yaml:",inline"`
+	CheckUserContactForPhoneSet    bool                 `yaml:"informUserIfContactPhoneNumberMissing"`
+	InformManagerIfUnmatched       bool                 `yaml:"informTeamManagerIfUserUnmatched"`
+	ObjectsToSync                  SyncObjects          `yaml:"syncObjects"`
+}
+
+// PagerdutyEscalationPolicyToSlackGroup Struct
+type PagerdutyEscalationPolicyToSlackGroup struct {
+	CrontabExpressionForRepetition string               `yaml:"crontabExpressionForRepetition"`
+	Timezone                       Timezone             `yaml:"timezone"`
+	Notify                         NotifyPolicy         `yaml:"notify"`
+	InfoMessage                    InfoMessageOptions   `yaml:",inline"`
+	EmptyRotation                  EmptyRotationOptions `yaml:",inline"`
+	EscalationLevels               []uint               `yaml:"escalationLevels"` // levels to sync, all if empty
+	CheckUserContactForPhoneSet    bool                 `yaml:"informUserIfContactPhoneNumberMissing"`
+	InformManagerIfUnmatched       bool                 `yaml:"informTeamManagerIfUserUnmatched"`
+	ObjectsToSync                  SyncObjects          `yaml:"syncObjects"`
 }
 
 // SyncObjects Struct
@@ -93,8 +340,23 @@ type SyncObjects struct {
 	PagerdutyObjectIDs []string `yaml:"pdObjectIds"`
 }
 
-// NewConfig reads the configuration from the given filePath.
+// NewConfig reads the configuration from the given filePath and loads the credentials.
 func NewConfig(configFilePath string) (cfg Config, err error) {
+	cfg, err = ReadConfig(configFilePath)
+	if err != nil {
+		return cfg, err
+	}
+	if _, err = cfg.Slack.LoadSecrets(); err != nil {
+		return cfg, err
+	}
+	if _, err = cfg.Pagerduty.LoadSecrets(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// ReadConfig reads the configuration from the given filePath without loading the credentials.
+func ReadConfig(configFilePath string) (cfg Config, err error) {
 	if configFilePath == "" {
 		return cfg, fmt.Errorf("path to configuration file not provided")
 	}
@@ -103,38 +365,106 @@ func NewConfig(configFilePath string) (cfg Config, err error) {
 	if err != nil {
 		return cfg, fmt.Errorf("reading configuration file failed: %w", err)
 	}
-	err = yaml.Unmarshal(cfgBytes, &cfg)
-	if err != nil {
-		return cfg, fmt.Errorf("parsing configuration failed: %w", err)
-	}
-	err = loadEnvVars(&cfg)
+	err = unmarshalStrict(cfgBytes, &cfg)
 	if err != nil {
 		return cfg, err
 	}
-	return cfg, nil
-}
-
-// loadEnvVars fills credentials in the config from env vars
-func loadEnvVars(cfg *Config) error {
-	cfg.Slack.BotSecurityToken = os.Getenv("SLACK_BOT_TOKEN")
-	if cfg.Slack.BotSecurityToken == "" {
-		return fmt.Errorf("env variable `SLACK_BOT_TOKEN` is not set")
+	cfg.ConfigFilePath = configFilePath
+	if cfg.Global.ListenAddress == "" {
+		cfg.Global.ListenAddress = ":8080"
 	}
-
-	cfg.Slack.UserSecurityToken = os.Getenv("SLACK_USER_TOKEN")
-	if cfg.Slack.UserSecurityToken == "" {
-		return fmt.Errorf("env variable `SLACK_USER_TOKEN` is not set")
+	if cfg.Global.MasterDataMaxFailureDuration == 0 {
+		cfg.Global.MasterDataMaxFailureDuration = 3 * time.Hour
 	}
-
-	cfg.Pagerduty.AuthToken = os.Getenv("PAGERDUTY_TOKEN")
-	if cfg.Pagerduty.AuthToken == "" {
-		return fmt.Errorf("env variable `PAGERDUTY_TOKEN` is not set")
+	if cfg.Global.ConfigReloadInterval == 0 {
+		cfg.Global.ConfigReloadInterval = time.Minute
+	}
+	if cfg.Global.Timezone == "" {
+		cfg.Global.Timezone = "UTC"
+	}
+	if cfg.Global.Retry.MaxAttempts == 0 {
+		cfg.Global.Retry.MaxAttempts = 5
+	}
+	if cfg.Global.Retry.BaseDelay == 0 {
+		cfg.Global.Retry.BaseDelay = time.Second
+	}
+	if cfg.Global.Retry.MaxDelay == 0 {
+		cfg.Global.Retry.MaxDelay = time.Minute
+	}
+	if cfg.Global.JobDeadline == 0 {
+		cfg.Global.JobDeadline = 10 * time.Minute
 	}
+	if cfg.Global.Notify == "" {
+		cfg.Global.Notify = NotifyAlways
+	}
+	if cfg.Pagerduty.UserCacheTTL == 0 {
+		cfg.Pagerduty.UserCacheTTL = time.Hour
+	}
+	if cfg.Slack.DirectMessageInterval == 0 {
+		cfg.Slack.DirectMessageInterval = 24 * time.Hour
+	}
+	if len(cfg.Slack.UserMatching.Strategies) == 0 {
+		cfg.Slack.UserMatching.Strategies = []MatchStrategy{MatchEmail}
+	}
+	if cfg.Slack.UserMatching.NameSimilarity == 0 {
+		cfg.Slack.UserMatching.NameSimilarity = 0.9
+	}
+	for i := range cfg.Jobs.ScheduleSync {
+		if cfg.Jobs.ScheduleSync[i].SyncOptions.SyncStyle == "" {
+			cfg.Jobs.ScheduleSync[i].SyncOptions.SyncStyle = AllActiveLayers
+		}
+		if cfg.Jobs.ScheduleSync[i].Timezone == "" {
+			cfg.Jobs.ScheduleSync[i].Timezone = cfg.Global.Timezone
+		}
+		if cfg.Jobs.ScheduleSync[i].Notify == "" {
+			cfg.Jobs.ScheduleSync[i].Notify = cfg.Global.Notify
+		}
+		if s := &cfg.Jobs.ScheduleSync[i]; s.DisableHandleIfNoneOnShift || s.SyncOptions.DisableSlackHandleTemporaryIfNoneOnShift {
+			s.EmptyRotation.setDefault(EmptyRotationDisable)
+		} else {
+			s.EmptyRotation.setDefault(EmptyRotationKeep)
+		}
+	}
+	for i := range cfg.Jobs.TeamSync {
+		if cfg.Jobs.TeamSync[i].Timezone == "" {
+			cfg.Jobs.TeamSync[i].Timezone = cfg.Global.Timezone
+		}
+		if cfg.Jobs.TeamSync[i].Notify == "" {
+			cfg.Jobs.TeamSync[i].Notify = cfg.Global.Notify
+		}
+		cfg.Jobs.TeamSync[i].EmptyRotation.setDefault(EmptyRotationKeep)
+	}
+	for i := range cfg.Jobs.PolicySync {
+		if cfg.Jobs.PolicySync[i].Timezone == "" {
+			cfg.Jobs.PolicySync[i].Timezone = cfg.Global.Timezone
+		}
+		if cfg.Jobs.PolicySync[i].Notify == "" {
+			cfg.Jobs.PolicySync[i].Notify = cfg.Global.Notify
+		}
+		cfg.Jobs.PolicySync[i].EmptyRotation.setDefault(EmptyRotationKeep)
+	}
+	return cfg, nil
+}
 
-	cfg.Pagerduty.APIUser = os.Getenv("PAGERDUTY_USER")
-	if cfg.Pagerduty.APIUser == "" {
-		return fmt.Errorf("env variable `PAGERDUTY_USER` is not set")
+// unmarshalStrict decodes the configuration rejecting unknown keys and invalid values.
+// All errors are aggregated into a ValidationError.
+func unmarshalStrict(cfgBytes []byte, cfg *Config) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(cfgBytes, &root); err != nil {
+		return fmt.Errorf("parsing configuration failed: %w", err)
 	}
 
-	return nil
+	decoder := yaml.NewDecoder(bytes.NewReader(cfgBytes))
+	decoder.KnownFields(true)
+	err := decoder.Decode(cfg)
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil:
+		cfg.lines = keyLines(&root)
+		return nil
+	case errors.As(err, &typeErr):
+		return newValidationError(&root, typeErr.Errors)
+	default:
+		return fmt.Errorf("parsing configuration failed: %w", err)
+	}
 }
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing config failed: %s", err.Error())
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	path := writeConfig(t, `
+global:
+  write: true
+jobs:
+  pd-schedules-on-duty-to-slack-group:
+    - crontabExpressionForRepetition: 1 * * * *
+      syncOptions:
+        handoverTimeFrameForward: "30m"
+      syncObjects:
+        slackGroupHandle: onduty-1
+        pdObjectIds: [P123]
+`)
+
+	cfg, err := ReadConfig(path)
+
+	if assert.NoError(t, err) {
+		assert.True(t, cfg.Global.Write)
+		if assert.Len(t, cfg.Jobs.ScheduleSync, 1) {
+			opts := cfg.Jobs.ScheduleSync[0].SyncOptions
+			assert.Equal(t, 30*time.Minute, opts.HandoverTimeFrameForward)
+			assert.Equal(t, time.Duration(0), opts.HandoverTimeFrameBackward)
+			assert.Equal(t, AllActiveLayers, opts.SyncStyle)
+		}
+	}
+}
+
+func TestReadConfigStrict(t *testing.T) {
+	path := writeConfig(t, `
+global:
+  wirte: true
+jobs:
+  pd-schedules-on-duty-to-slack-group:
+    - crontabExpressionForRepetition: 1 * * * *
+      syncOptions:
+        handoverTimeFrameForward: "30m"
+      syncObjects:
+        slackGroupHandle: onduty-1
+    - crontabExpressionForRepetition: 1 * * * *
+      syncOptions:
+        handoverTimeFrameBackward: "30x"
+        syncStlye: FinalLayer
+        syncStyle: LastLayer
+      syncObjects:
+        slackGroupHandle: onduty-2
+`)
+
+	_, err := ReadConfig(path)
+
+	var vErr *ValidationError
+	if assert.True(t, errors.As(err, &vErr), "expected ValidationError, got %v", err) {
+		assert.Equal(t, []FieldError{
+			{Line: 3, Message: "field wirte not found in type config.GlobalConfig"},
+			{Job: "pd-schedules-on-duty-to-slack-group[1]", Line: 13, Message: "cannot unmarshal !!str `30x` into time.Duration"},
+			{Job: "pd-schedules-on-duty-to-slack-group[1]", Line: 14, Message: "field syncStlye not found in type config.ScheduleSyncOptions"},
+			{Job: "pd-schedules-on-duty-to-slack-group[1]", Line: 15, Message: "unknown sync style 'LastLayer', use one of FinalLayer, OverridesOnlyIfThere, AllActiveLayers"},
+		}, vErr.Errors)
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	dir := t.TempDir()
+	botFile := filepath.Join(dir, "bot")
+	userFile := filepath.Join(dir, "user")
+	assert.NoError(t, os.WriteFile(botFile, []byte("bot-from-config-file\n"), 0o600))
+	assert.NoError(t, os.WriteFile(userFile, []byte("user-from-env-file"), 0o600))
+
+	t.Setenv("SLACK_BOT_TOKEN", "bot-from-env")
+	t.Setenv("SLACK_USER_TOKEN", "user-from-env")
+	t.Setenv("SLACK_USER_TOKEN_FILE", userFile)
+
+	cfg := SlackConfig{BotSecurityTokenFile: botFile}
+	changed, err := cfg.LoadSecrets()
+	if assert.NoError(t, err) {
+		assert.True(t, changed)
+		assert.Equal(t, "bot-from-config-file", cfg.BotSecurityToken)
+		assert.Equal(t, "user-from-env-file", cfg.UserSecurityToken)
+	}
+
+	changed, err = cfg.LoadSecrets()
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// rotation
+	assert.NoError(t, os.WriteFile(botFile, []byte("rotated"), 0o600))
+	changed, err = cfg.LoadSecrets()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "rotated", cfg.BotSecurityToken)
+}
+
+func TestLoadSecretsMissing(t *testing.T) {
+	t.Setenv("PAGERDUTY_TOKEN", "secret-token")
+	t.Setenv("PAGERDUTY_USER", "")
+	t.Setenv("PAGERDUTY_USER_FILE", "")
+
+	cfg := PagerdutyConfig{}
+	_, err := cfg.LoadSecrets()
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "secret-token")
+	}
+}
+
+func TestReadConfigTimezone(t *testing.T) {
+	path := writeConfig(t, `
+global:
+  timezone: Europe/Berlin
+jobs:
+  pd-schedules-on-duty-to-slack-group:
+    - crontabExpressionForRepetition: 1 * * * *
+      syncObjects:
+        slackGroupHandle: onduty-1
+  pd-teams-to-slack-group:
+    - crontabExpressionForRepetition: 0 9 * * 1-5
+      timezone: America/New_York
+      syncObjects:
+        slackGroupHandle: team-1
+`)
+
+	cfg, err := ReadConfig(path)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, Timezone("Europe/Berlin"), cfg.Jobs.ScheduleSync[0].Timezone)
+		assert.Equal(t, Timezone("America/New_York"), cfg.Jobs.TeamSync[0].Timezone)
+		assert.Equal(t, "America/New_York", cfg.Jobs.TeamSync[0].Timezone.Location().String())
+	}
+
+	path = writeConfig(t, `
+jobs:
+  pd-teams-to-slack-group:
+    - crontabExpressionForRepetition: 0 9 * * 1-5
+      timezone: Europe/Walldorf
+`)
+	_, err = ReadConfig(path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "pd-teams-to-slack-group[0], line 5: unknown time zone 'Europe/Walldorf'")
+	}
+}
+
+func TestReadConfigNotify(t *testing.T) {
+	path := writeConfig(t, `
+global:
+  notify: onChange
+jobs:
+  pd-schedules-on-duty-to-slack-group:
+    - crontabExpressionForRepetition: 1 * * * *
+      syncObjects:
+        slackGroupHandle: onduty-1
+  pd-teams-to-slack-group:
+    - crontabExpressionForRepetition: 0 9 * * 1-5
+      notify: never
+      syncObjects:
+        slackGroupHandle: team-1
+`)
+
+	cfg, err := ReadConfig(path)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, NotifyOnChange, cfg.Jobs.ScheduleSync[0].Notify)
+		assert.Equal(t, NotifyNever, cfg.Jobs.TeamSync[0].Notify)
+	}
+
+	path = writeConfig(t, `
+global:
+  notify: sometimes
+`)
+	_, err = ReadConfig(path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown notify policy 'sometimes'")
+	}
+}
+
+func TestReadConfigEmptyRotation(t *testing.T) {
+	path := writeConfig(t, `
+jobs:
+  pd-schedules-on-duty-to-slack-group:
+    - crontabExpressionForRepetition: 1 * * * *
+      syncOptions:
+        disableSlackHandleTemporaryIfNoneOnShift: true
+    - crontabExpressionForRepetition: 1 * * * *
+  pd-teams-to-slack-group:
+    - crontabExpressionForRepetition: 0 9 * * 1-5
+      emptyRotation: disable
+  pd-escalation-policies-to-slack-group:
+    - crontabExpressionForRepetition: 0 * * * *
+      fallback:
+        emails: [manager@example.com]
+`)
+
+	cfg, err := ReadConfig(path)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, EmptyRotationDisable, cfg.Jobs.ScheduleSync[0].EmptyRotation.Behavior)
+		assert.Equal(t, EmptyRotationKeep, cfg.Jobs.ScheduleSync[1].EmptyRotation.Behavior)
+		assert.Equal(t, EmptyRotationDisable, cfg.Jobs.TeamSync[0].EmptyRotation.Behavior)
+		assert.Equal(t, EmptyRotationFallback, cfg.Jobs.PolicySync[0].EmptyRotation.Behavior)
+		assert.Equal(t, []string{"manager@example.com"}, cfg.Jobs.PolicySync[0].EmptyRotation.Fallback.Emails)
+	}
+}
+
+func TestReadConfigUserMatching(t *testing.T) {
+	path := writeConfig(t, `
+slack:
+  userMatching:
+    strategies: [email, emailAlias, name]
+    domainAliases:
+      example.org: example.com
+`)
+
+	cfg, err := ReadConfig(path)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, []MatchStrategy{MatchEmail, MatchEmailAlias, MatchName}, cfg.Slack.UserMatching.Strategies)
+		assert.Equal(t, map[string]string{"example.org": "example.com"}, cfg.Slack.UserMatching.DomainAliases)
+		assert.Equal(t, 0.9, cfg.Slack.UserMatching.NameSimilarity)
+	}
+
+	path = writeConfig(t, `
+slack:
+  userMatching:
+    strategies: [emial]
+`)
+	_, err = ReadConfig(path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown match strategy 'emial'")
+	}
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// FieldError is a single problem in the configuration
+type FieldError struct {
+	Job     string // e.g. `pd-schedules-on-duty-to-slack-group[0]`, empty if not inside a job
+	Line    int    // line in the YAML file, 0 if unknown
+	Message string
+}
+
+func (e FieldError) Error() string {
+	var prefix []string
+	if e.Job != "" {
+		prefix = append(prefix, e.Job)
+	}
+	if e.Line > 0 {
+		prefix = append(prefix, fmt.Sprintf("line %d", e.Line))
+	}
+	if len(prefix) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(prefix, ", "), e.Message)
+}
+
+// ValidationError aggregates all problems found decoding the configuration
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+	return fmt.Sprintf("invalid configuration:\n  %s", strings.Join(msgs, "\n  "))
+}
+
+var lineErrorRx = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// jobPosition is the range of lines of a job in the YAML file
+type jobPosition struct {
+	name       string
+	start, end int
+}
+
+// newValidationError maps the yaml decoding errors to the jobs they occurred in
+func newValidationError(root *yaml.Node, yamlErrors []string) *ValidationError {
+	positions := jobPositions(root)
+	vErr := &ValidationError{}
+	for _, msg := range yamlErrors {
+		fe := FieldError{Message: msg}
+		if m := lineErrorRx.FindStringSubmatch(msg); m != nil {
+			fe.Line, _ = strconv.Atoi(m[1]) //nolint:errcheck // matched digits only
+			fe.Message = m[2]
+		}
+		for _, p := range positions {
+			if fe.Line >= p.start && fe.Line <= p.end {
+				fe.Job = p.name
+				break
+			}
+		}
+		vErr.Errors = append(vErr.Errors, fe)
+	}
+	return vErr
+}
+
+// jobPositions returns the line range of every job below the `jobs` key
+func jobPositions(root *yaml.Node) []jobPosition {
+	var positions []jobPosition
+	doc := root
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+	jobs := mappingValue(doc, "jobs")
+	if jobs == nil || jobs.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(jobs.Content); i += 2 {
+		kind, list := jobs.Content[i].Value, jobs.Content[i+1]
+		if list.Kind != yaml.SequenceNode {
+			continue
+		}
+		for idx, job := range list.Content {
+			positions = append(positions, jobPosition{
+				name:  fmt.Sprintf("%s[%d]", kind, idx),
+				start: job.Line,
+				end:   lastLine(job),
+			})
+		}
+	}
+	return positions
+}
+
+// mappingValue returns the value node of the key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// lastLine returns the highest line number of the node and its children
+func lastLine(node *yaml.Node) int {
+	line := node.Line
+	for _, c := range node.Content {
+		if l := lastLine(c); l > line {
+			line = l
+		}
+	}
+	return line
+}
+
+// keyLines returns the line of every mapping key and sequence item below the root by its path,
+// e.g. `jobs.pd-teams-to-slack-group[0].syncObjects`
+func keyLines(root *yaml.Node) map[string]int {
+	lines := make(map[string]int)
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, c := range node.Content {
+				walk(c, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i].Value
+				if path != "" {
+					key = path + "." + key
+				}
+				lines[key] = node.Content[i].Line
+				walk(node.Content[i+1], key)
+			}
+		case yaml.SequenceNode:
+			for i, c := range node.Content {
+				item := fmt.Sprintf("%s[%d]", path, i)
+				lines[item] = c.Line
+				walk(c, item)
+			}
+		}
+	}
+	walk(root, "")
+	return lines
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadSecrets (re-)reads the slack tokens, it returns true if any token changed
+func (c *SlackConfig) LoadSecrets() (changed bool, err error) {
+	bot, err := readSecret(c.BotSecurityTokenFile, "SLACK_BOT_TOKEN")
+	if err != nil {
+		return false, err
+	}
+	user, err := readSecret(c.UserSecurityTokenFile, "SLACK_USER_TOKEN")
+	if err != nil {
+		return false, err
+	}
+	changed = bot != c.BotSecurityToken || user != c.UserSecurityToken
+	c.BotSecurityToken, c.UserSecurityToken = bot, user
+	return changed, nil
+}
+
+// LoadSecrets (re-)reads the pagerduty token and api user, it returns true if any of them changed
+func (c *PagerdutyConfig) LoadSecrets() (changed bool, err error) {
+	token, err := readSecret(c.AuthTokenFile, "PAGERDUTY_TOKEN")
+	if err != nil {
+		return false, err
+	}
+	user, err := readSecret(c.APIUserFile, "PAGERDUTY_USER")
+	if err != nil {
+		return false, err
+	}
+	changed = token != c.AuthToken || user != c.APIUser
+	c.AuthToken, c.APIUser = token, user
+	return changed, nil
+}
+
+// readSecret returns the secret from the file configured, the file given by env variable `<envVar>_FILE`
+// or the env variable `<envVar>` in this order. Errors never contain the secret.
+func readSecret(file, envVar string) (string, error) {
+	source := fmt.Sprintf("file '%s'", file)
+	if file == "" {
+		file = os.Getenv(envVar + "_FILE")
+		source = fmt.Sprintf("file '%s' of env variable `%s_FILE`", file, envVar)
+	}
+
+	if file == "" {
+		secret := os.Getenv(envVar)
+		if secret == "" {
+			return "", fmt.Errorf("env variable `%s` or `%s_FILE` is not set", envVar, envVar)
+		}
+		return secret, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("reading secret from %s failed: %w", source, err)
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("secret in %s is empty", source)
+	}
+	return secret, nil
+}
@@ -0,0 +1,99 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status tracks the readiness of the clients and the scheduler
+type Status struct {
+	mutex sync.RWMutex
+
+	slackReady     bool // slack client created and master data loaded
+	pagerdutyReady bool // pagerduty client created and api user resolved
+	schedulerReady bool // all jobs registered at the scheduler
+
+	masterDataFailingSince     time.Time     // first failed master data load after the last successful one
+	masterDataMaxFailureWindow time.Duration // how long master data loads may fail until not ready
+}
+
+// NewStatus returns a new Status; master data loads may fail for maxFailureWindow until the status is not ready
+func NewStatus(maxFailureWindow time.Duration) *Status {
+	return &Status{masterDataMaxFailureWindow: maxFailureWindow}
+}
+
+// SetSlackReady marks the slack client as initialized with loaded master data
+func (s *Status) SetSlackReady() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.slackReady = true
+}
+
+// SetPagerdutyReady marks the pagerduty client as initialized with resolved api user
+func (s *Status) SetPagerdutyReady() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pagerdutyReady = true
+}
+
+// SetSchedulerReady marks all jobs as registered at the scheduler
+func (s *Status) SetSchedulerReady() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.schedulerReady = true
+}
+
+// ObserveMasterDataLoad records the result of a slack master data load
+func (s *Status) ObserveMasterDataLoad(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err == nil {
+		s.masterDataFailingSince = time.Time{}
+		return
+	}
+	if s.masterDataFailingSince.IsZero() {
+		s.masterDataFailingSince = time.Now()
+	}
+}
+
+// Ready returns an error describing all reasons why the service is not ready
+func (s *Status) Ready() error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var reasons []string
+	if !s.slackReady {
+		reasons = append(reasons, "slack client not initialized")
+	}
+	if !s.pagerdutyReady {
+		reasons = append(reasons, "pagerduty client not initialized")
+	}
+	if !s.schedulerReady {
+		reasons = append(reasons, "jobs not scheduled")
+	}
+	if !s.masterDataFailingSince.IsZero() && time.Since(s.masterDataFailingSince) > s.masterDataMaxFailureWindow {
+		reasons = append(reasons, fmt.Sprintf("loading slack master data failing since %s", s.masterDataFailingSince.Format(time.RFC3339)))
+	}
+
+	if len(reasons) > 0 {
+		return fmt.Errorf("health: not ready: %s", strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+// RegisterHandlers adds the liveness endpoint `/healthz` and the readiness endpoint `/readyz` to the mux
+func (s *Status) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,54 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReady(t *testing.T) {
+	s := NewStatus(time.Hour)
+	assert.Error(t, s.Ready())
+
+	s.SetSlackReady()
+	s.SetPagerdutyReady()
+	assert.Error(t, s.Ready())
+
+	s.SetSchedulerReady()
+	assert.NoError(t, s.Ready())
+}
+
+func TestReadyMasterDataFailing(t *testing.T) {
+	s := NewStatus(time.Hour)
+	s.SetSlackReady()
+	s.SetPagerdutyReady()
+	s.SetSchedulerReady()
+
+	s.ObserveMasterDataLoad(fmt.Errorf("slack: failed retrieving users"))
+	assert.NoError(t, s.Ready(), "failing within window must not affect readiness")
+
+	s.masterDataFailingSince = time.Now().Add(-2 * time.Hour)
+	s.ObserveMasterDataLoad(fmt.Errorf("slack: failed retrieving users"))
+	assert.Error(t, s.Ready())
+
+	s.ObserveMasterDataLoad(nil)
+	assert.NoError(t, s.Ready())
+}
+
+func TestReadyzHandler(t *testing.T) {
+	s := NewStatus(time.Hour)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", http.NoBody))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
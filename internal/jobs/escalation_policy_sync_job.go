@@ -0,0 +1,194 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/metrics"
+)
+
+type PagerdutyEscalationPolicyToSlackJob struct {
+	schedule           cron.Schedule               // on which this job runs
+	location           *time.Location              // time zone of the schedule
+	dryrun             bool                        // when enabled changes are not manifested
+	err                error                       // err used for slack info message
+	result             syncResult                  // of the last run
+	emptyRotation      config.EmptyRotationOptions // behavior if nobody is on shift
+	infoMessage        config.InfoMessageOptions   // where the info messages are posted
+	informMissingPhone bool                        // inform users without phone contact method
+	informManager      bool                        // inform the team managers of users without slack user
+
+	pd          *pagerdutyclient.Client // pagerduty API access
+	slackClient *slackclient.Client     // slack API access
+
+	slackHandle        string                       // of the target user group
+	pagerDutyIDs       []string                     // IDs of the escalation policies to sync
+	escalationLevels   []uint                       // levels to sync, all if empty
+	onCallUsers        []pagerdutyclient.OnCallUser // users on call with their levels
+	matches            []slackclient.Match          // of the users on call to slack users
+	escalationPolicies []pagerduty.APIObject        // pagerduty escalation policies synced by this job
+}
+
+// NewEscalationPolicySyncJob creates a new job to sync users on call for pagerduty escalation policies to a slack user group
+func NewEscalationPolicySyncJob(cfg config.PagerdutyEscalationPolicyToSlackGroup, dryrun bool, pd *pagerdutyclient.Client, slackClient *slackclient.Client) (*PagerdutyEscalationPolicyToSlackJob, error) {
+	schedule, err := ParseSchedule(cfg.CrontabExpressionForRepetition, cfg.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return &PagerdutyEscalationPolicyToSlackJob{
+		schedule:           schedule,
+		location:           cfg.Timezone.Location(),
+		dryrun:             dryrun,
+		slackHandle:        cfg.ObjectsToSync.SlackGroupHandle,
+		pagerDutyIDs:       cfg.ObjectsToSync.PagerdutyObjectIDs,
+		escalationLevels:   cfg.EscalationLevels,
+		pd:                 pd,
+		slackClient:        slackClient,
+		infoMessage:        cfg.InfoMessage,
+		emptyRotation:      cfg.EmptyRotation,
+		informMissingPhone: cfg.CheckUserContactForPhoneSet,
+		informManager:      cfg.InformManagerIfUnmatched,
+	}, nil
+}
+
+// Run syncs the users on call for the escalation policies to slack user group
+func (e *PagerdutyEscalationPolicyToSlackJob) Run(ctx context.Context) error {
+	log.Info(e.Name())
+	e.err = nil
+	e.result = syncResult{}
+
+	slackUsers, err := e.resolveSlackUsers(ctx)
+	if err != nil {
+		e.err = err
+		return err
+	}
+	unmatched := unmatchedPDUsers(e.pagerdutyUsers(), e.matches)
+	metrics.SetUnmatchedUsers(e, len(unmatched))
+	// reported even if the sync fails, the users are missing in the group anyway
+	e.result.unmatched = unmatched
+	if e.informManager && len(unmatched) > 0 {
+		informTeamManagers(ctx, e.pd, e.slackClient, unmatched, e.dryrun)
+	}
+
+	result, err := syncGroup(ctx, e.pd, e.slackClient, e.slackHandle, slackUsers, e.emptyRotation, e.dryrun)
+	result.unmatched = unmatched
+	if err != nil {
+		e.err = err
+		return fmt.Errorf("job: adding on call members to slack group %s failed: %w", e.slackHandle, err)
+	}
+	if e.informMissingPhone {
+		result.withoutPhone = informUsersWithoutPhone(ctx, e.pd, e.slackClient, e.pagerdutyUsers(), e.dryrun)
+	}
+	e.result = result
+	observeGroupChange(e, result.change)
+	return nil
+}
+
+// Plan returns the changes Run would apply to the slack user group
+func (e *PagerdutyEscalationPolicyToSlackJob) Plan(ctx context.Context) (*Plan, error) {
+	if _, err := e.resolveSlackUsers(ctx); err != nil {
+		return nil, err
+	}
+	return planGroupChange(ctx, e, e.pd, e.slackClient, e.matches, e.emptyRotation)
+}
+
+// resolveSlackUsers returns the slack users of the pagerduty users on call
+func (e *PagerdutyEscalationPolicyToSlackJob) resolveSlackUsers(ctx context.Context) ([]slack.User, error) {
+	onCallUsers, policies, err := e.pd.ListEscalationPolicyOnCalls(ctx, e.pagerDutyIDs, e.escalationLevels)
+	if err != nil {
+		return nil, fmt.Errorf("job: listing on calls for escalation policies '%s' failed: %w", strings.Join(e.pagerDutyIDs, ","), err)
+	}
+	e.onCallUsers = onCallUsers
+	e.escalationPolicies = policies
+
+	e.matches, err = e.slackClient.Match(ctx, e.pagerdutyUsers())
+	return slackclient.SlackUsers(e.matches), err
+}
+
+// pagerdutyUsers returns the pagerduty users on call
+func (e *PagerdutyEscalationPolicyToSlackJob) pagerdutyUsers() []pagerduty.User {
+	var users []pagerduty.User
+	for _, u := range e.onCallUsers {
+		users = append(users, u.User)
+	}
+	return users
+}
+
+// Name of the job
+func (e *PagerdutyEscalationPolicyToSlackJob) Name() string {
+	return fmt.Sprintf("job: sync pagerduty escalation policy(s) '%s' to slack group: '%s'", strings.Join(e.pagerDutyIDs, ","), e.slackHandle)
+}
+
+// Icon returns name of icon to show in Slack messages
+func (e *PagerdutyEscalationPolicyToSlackJob) Icon() string {
+	return ":rotating_light:"
+}
+
+// JobType as string
+func (e *PagerdutyEscalationPolicyToSlackJob) JobType() string {
+	return string(PdEscalationPolicySync)
+}
+
+// SlackHandle of the slack user group
+func (e *PagerdutyEscalationPolicyToSlackJob) SlackHandle() string {
+	return e.slackHandle
+}
+
+// PagerDutyObjects returns the pagerduty escalation policies synced
+func (e *PagerdutyEscalationPolicyToSlackJob) PagerDutyObjects() []pagerduty.APIObject {
+	return e.escalationPolicies
+}
+
+// SlackInfoMessageBody returns TextBlock with the pagerduty users on call and their escalation levels
+func (e *PagerdutyEscalationPolicyToSlackJob) SlackInfoMessageBody() *slack.TextBlockObject {
+	var sL []string
+	for _, u := range e.onCallUsers {
+		var levels []string
+		for _, l := range u.EscalationLevels {
+			levels = append(levels, fmt.Sprintf("L%d", l))
+		}
+		sL = append(sL, fmt.Sprintf("<%s|%s> (%s)", u.User.HTMLURL, u.User.Summary, strings.Join(levels, ", ")))
+	}
+
+	return &slack.TextBlockObject{
+		Type:     slack.MarkdownType,
+		Text:     fmt.Sprintf("*Who is on call:*\n - %s", strings.Join(sL, ",\n - ")),
+		Emoji:    false,
+		Verbatim: false,
+	}
+}
+
+// Dryrun is true when the job is not performing changes
+func (e *PagerdutyEscalationPolicyToSlackJob) Dryrun() bool {
+	return e.dryrun
+}
+
+// NextRun returns the time from now when the cron is next executed
+func (e *PagerdutyEscalationPolicyToSlackJob) NextRun() time.Time {
+	return e.schedule.Next(time.Now()).In(e.location)
+}
+
+// Error if any occurred during the sync
+func (e *PagerdutyEscalationPolicyToSlackJob) Error() error {
+	return e.err
+}
+
+// ChangeSummary returns the users added to and removed from the slack user group by the last run
+func (e *PagerdutyEscalationPolicyToSlackJob) ChangeSummary() ChangeSummary {
+	return newChangeSummary(e.result, e.matches)
+}
+
+// InfoMessageOptions returns where the info messages are posted
+func (e *PagerdutyEscalationPolicyToSlackJob) InfoMessageOptions() config.InfoMessageOptions {
+	return e.infoMessage
+}
@@ -0,0 +1,66 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PagerDuty/go-pagerduty"
+	"github.com/slack-go/slack"
+
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/config"
+)
+
+// fallbackUsers returns the slack users of the fallback combined from all configured sources, each user once
+func fallbackUsers(ctx context.Context, pd *pagerdutyclient.Client, c *slackclient.Client, f config.Fallback) ([]slack.User, error) {
+	users, err := c.UsersByID(f.SlackUserIDs)
+	if err != nil {
+		return nil, fmt.Errorf("job: fallback: %w", err)
+	}
+	byEmail, err := c.UsersByEmail(f.Emails)
+	if err != nil {
+		return nil, fmt.Errorf("job: fallback: %w", err)
+	}
+	users = append(users, byEmail...)
+
+	if f.SlackGroupHandle != "" {
+		members, err := c.GroupMembers(ctx, f.SlackGroupHandle)
+		if err != nil {
+			return nil, fmt.Errorf("job: fallback: %w", err)
+		}
+		users = append(users, members...)
+	}
+
+	if f.EscalationPolicyID != "" {
+		// only the first level, who would be notified first
+		onCalls, _, err := pd.ListEscalationPolicyOnCalls(ctx, []string{f.EscalationPolicyID}, []uint{1})
+		if err != nil {
+			return nil, fmt.Errorf("job: fallback: listing on calls for escalation policy '%s' failed: %w", f.EscalationPolicyID, err)
+		}
+		pdUsers := make([]pagerduty.User, 0, len(onCalls))
+		for _, u := range onCalls {
+			pdUsers = append(pdUsers, u.User)
+		}
+		matched, err := c.MatchPDUsers(ctx, pdUsers)
+		if err != nil {
+			return nil, fmt.Errorf("job: fallback: %w", err)
+		}
+		users = append(users, matched...)
+	}
+
+	if len(users) == 0 {
+		return nil, fmt.Errorf("job: fallback has no members")
+	}
+	// a user may be given by several sources
+	seen := make(map[string]struct{}, len(users))
+	unique := make([]slack.User, 0, len(users))
+	for _, u := range users {
+		if _, ok := seen[u.ID]; ok {
+			continue
+		}
+		seen[u.ID] = struct{}{}
+		unique = append(unique, u)
+	}
+	return unique, nil
+}
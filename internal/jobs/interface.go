@@ -1,25 +1,34 @@
 package jobs
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/PagerDuty/go-pagerduty"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
 	"github.com/slack-go/slack"
 
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
 	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/metrics"
 )
 
 // ObjectSyncType
 type ObjectSyncType string
 
 const (
-	PdScheduleSync ObjectSyncType = "PD Schedule"
-	PdTeamSync     ObjectSyncType = "PD Team"
+	PdScheduleSync         ObjectSyncType = "PD Schedule"
+	PdTeamSync             ObjectSyncType = "PD Team"
+	PdEscalationPolicySync ObjectSyncType = "PD Escalation Policy"
 )
 
 type SyncJob interface {
+	// Run syncs the pagerduty users to the slack user group
+	Run(ctx context.Context) error
 	// Name of the job
 	Name() string
 	// Icon returns name of icon to show in Slack messages
@@ -34,28 +43,155 @@ type SyncJob interface {
 	SlackInfoMessageBody() *slack.TextBlockObject
 	// Dryrun is true when the job is not performing changes
 	Dryrun() bool
-	// NextRun returns the time from now when the cron is next executed
+	// NextRun returns the time from now when the cron is next executed, in the time zone of the job
 	NextRun() time.Time
 	// Error if any occurred during the sync
 	Error() error
+	// Plan returns the changes a run would apply to the slack user group without writing them
+	Plan(ctx context.Context) (*Plan, error)
+	// ChangeSummary returns the users added to and removed from the slack user group by the last run
+	ChangeSummary() ChangeSummary
+	// InfoMessageOptions returns where the info messages are posted
+	InfoMessageOptions() config.InfoMessageOptions
 }
 
-// PostInfoMessage posts a message to slack with the current sync state of the job
-func PostInfoMessage(c *slackclient.Client, j SyncJob) error {
+// SummaryUser is a slack user added to or removed from a group
+type SummaryUser struct {
+	SlackID      string               // mentioned in the info message
+	PagerDutyURL string               // profile of the matching pagerduty user, empty if unknown
+	MatchedBy    config.MatchStrategy // strategy which matched the pagerduty user, empty if unknown
+}
+
+// ChangeSummary describes the change of a slack user group by a run
+type ChangeSummary struct {
+	Added         []SummaryUser
+	Removed       []SummaryUser
+	Members       int                  // in the group after the run
+	Enabled       bool                 // the group was enabled again
+	Disabled      bool                 // the group was disabled
+	EmptyRotation config.EmptyRotation // applied as nobody was on shift, empty otherwise
+	WithoutPhone  []pagerduty.User     // users without phone contact method, if checked
+	Unmatched     []pagerduty.User     // users without slack user
+}
+
+// HasChanges is true when users were added or removed or the group was enabled or disabled
+func (s ChangeSummary) HasChanges() bool {
+	return len(s.Added) > 0 || len(s.Removed) > 0 || s.Enabled || s.Disabled
+}
+
+// syncResult of the last run of a job
+type syncResult struct {
+	change        slackclient.GroupChange
+	emptyRotation config.EmptyRotation // applied as nobody was on shift, empty otherwise
+	withoutPhone  []pagerduty.User     // users without phone contact method, if checked
+	unmatched     []pagerduty.User     // users without slack user
+}
+
+// syncGroup sets the slack users as members of the slack group (found by handle). If nobody is on shift, the empty
+// rotation behavior is applied instead.
+func syncGroup(ctx context.Context, pd *pagerdutyclient.Client, c *slackclient.Client, handle string, slackUsers []slack.User, empty config.EmptyRotationOptions, dryrun bool) (syncResult, error) {
+	if len(slackUsers) > 0 {
+		change, err := c.AddToGroup(ctx, handle, slackUsers, dryrun)
+		return syncResult{change: change}, err
+	}
+
+	log.Infof("job: nobody on shift for slack group '%s', empty rotation behavior: %s", handle, empty.Behavior)
+	var change slackclient.GroupChange
+	var err error
+	switch empty.Behavior {
+	case config.EmptyRotationDisable:
+		change, err = c.DisableGroup(ctx, handle, dryrun)
+	case config.EmptyRotationFallback:
+		var fallback []slack.User
+		if fallback, err = fallbackUsers(ctx, pd, c, empty.Fallback); err == nil {
+			change, err = c.AddToGroup(ctx, handle, fallback, dryrun)
+		}
+	default:
+		change, err = c.KeepGroup(ctx, handle)
+	}
+	return syncResult{change: change, emptyRotation: empty.Behavior}, err
+}
+
+// newChangeSummary returns the summary of the sync linking the slack users to their matching pagerduty users
+func newChangeSummary(result syncResult, matches []slackclient.Match) ChangeSummary {
+	change := result.change
+	matchesBySlackID := make(map[string]slackclient.Match, len(matches))
+	for _, m := range matches {
+		matchesBySlackID[m.SlackUser.ID] = m
+	}
+	summaryUsers := func(users []slack.User) []SummaryUser {
+		var l []SummaryUser
+		for _, u := range users {
+			m := matchesBySlackID[u.ID]
+			l = append(l, SummaryUser{SlackID: u.ID, PagerDutyURL: m.PDUser.HTMLURL, MatchedBy: m.Strategy})
+		}
+		return l
+	}
+	return ChangeSummary{
+		Added:         summaryUsers(change.Added),
+		Removed:       summaryUsers(change.Removed),
+		Members:       len(change.Unchanged) + len(change.Added),
+		Enabled:       change.Enabled,
+		Disabled:      change.Disabled,
+		EmptyRotation: result.emptyRotation,
+		WithoutPhone:  result.withoutPhone,
+		Unmatched:     result.unmatched,
+	}
+}
+
+// ParseSchedule returns the schedule of the cron expression in the given time zone
+func ParseSchedule(crontab string, timezone config.Timezone) (cron.Schedule, error) {
+	tz := timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	schedule, err := cron.ParseStandard(fmt.Sprintf("TZ=%s %s", tz, crontab))
+	if err != nil {
+		return nil, fmt.Errorf("job: invalid cron schedule '%s' in time zone '%s': %w", crontab, tz, err)
+	}
+	return schedule, nil
+}
+
+// PostInfoMessage posts a message to slack with the current sync state of the job. Without error and changes
+// it's collapsed into a single line.
+func PostInfoMessage(ctx context.Context, c *slackclient.Client, j SyncJob) error {
 	divSection := slack.NewDividerBlock()
+	post := func(blocks ...slack.Block) error {
+		return postJobMessage(ctx, c, j, slack.MsgOptionBlocks(blocks...))
+	}
 
 	sHeaderText := fmt.Sprintf("%s %s > Slack Handle: `%s`", j.Icon(), j.JobType(), j.SlackHandle())
 	if j.Dryrun() {
 		sHeaderText += " - !!! DRY RUN !!! No update done !!!"
 	}
+
+	summary := j.ChangeSummary()
+	if j.Error() == nil && !summary.HasChanges() {
+		state := "no changes"
+		switch summary.EmptyRotation {
+		case "":
+		case config.EmptyRotationFallback:
+			state = ":sos: nobody on shift, *fallback* members"
+		default:
+			state = "nobody on shift, members kept"
+		}
+		if len(summary.Unmatched) > 0 {
+			state += fmt.Sprintf(", :warning: `%d` without slack user", len(summary.Unmatched))
+		}
+		if len(summary.WithoutPhone) > 0 {
+			state += fmt.Sprintf(", :telephone_receiver: `%d` without phone", len(summary.WithoutPhone))
+		}
+		text := fmt.Sprintf("%s - %s, `%d` member(s), next run %s", sHeaderText, state, summary.Members, j.NextRun().Format(time.RFC822))
+		return post(slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil), divSection)
+	}
+
 	headerText := slack.NewTextBlockObject(slack.MarkdownType, sHeaderText, false, false)
 	headerSection := slack.NewSectionBlock(headerText, nil, nil)
+	blocks := []slack.Block{headerSection}
 
-	var errorText *slack.TextBlockObject
-	var errorSection *slack.SectionBlock
 	if j.Error() != nil {
-		errorText = slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf(":stop-sign: *Error:* %s", j.Error()), false, false)
-		errorSection = slack.NewSectionBlock(errorText, nil, nil)
+		errorText := slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf(":stop-sign: *Error:* %s", j.Error()), false, false)
+		blocks = append(blocks, slack.NewSectionBlock(errorText, nil, nil))
 	}
 
 	var fields []*slack.TextBlockObject
@@ -77,11 +213,103 @@ func PostInfoMessage(c *slackclient.Client, j SyncJob) error {
 		Emoji:    false,
 		Verbatim: false,
 	})
-	//jobSection := slack.NewSectionBlock(jobText, fields, nil)
-	jobSection := slack.NewSectionBlock(nil, fields, nil)
+	blocks = append(blocks, slack.NewSectionBlock(nil, fields, nil))
+
+	if summary.HasChanges() {
+		changeText := slack.NewTextBlockObject(slack.MarkdownType, changeSummaryText(summary), false, false)
+		blocks = append(blocks, slack.NewSectionBlock(changeText, nil, nil))
+	}
+	if len(summary.Unmatched) > 0 {
+		var users []string
+		for _, u := range summary.Unmatched {
+			users = append(users, fmt.Sprintf("<%s|%s> (%s)", u.HTMLURL, u.Name, unmatchedReason(u)))
+		}
+		unmatchedText := fmt.Sprintf(":warning: *Without slack user, not synced:* %s", strings.Join(users, ", "))
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, unmatchedText, false, false), nil, nil))
+	}
+	if len(summary.WithoutPhone) > 0 {
+		var users []string
+		for _, u := range summary.WithoutPhone {
+			users = append(users, fmt.Sprintf("<%s|%s>", u.HTMLURL, u.Name))
+		}
+		phoneText := fmt.Sprintf(":telephone_receiver: *Without phone contact method:* %s", strings.Join(users, ", "))
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, phoneText, false, false), nil, nil))
+	}
+
+	return post(append(blocks, divSection)...)
+}
+
+// postJobMessage posts the message to the info channel of the job, as reply to the pinned message of the job if
+// configured
+func postJobMessage(ctx context.Context, c *slackclient.Client, j SyncJob, msg slack.MsgOption) error {
+	opts := j.InfoMessageOptions()
+	if !opts.Thread {
+		_, err := c.PostMessageTo(ctx, opts.Channel, msg)
+		return err
+	}
+	parent := fmt.Sprintf("%s %s for Slack Handle `%s`: history of the syncs in the thread", j.Icon(), j.JobType(), j.SlackHandle())
+	ts, err := c.ThreadParent(ctx, opts.Channel, parent)
+	if err != nil {
+		return err
+	}
+	_, err = c.PostMessageTo(ctx, opts.Channel, msg, slack.MsgOptionTS(ts))
+	return err
+}
+
+// changeSummaryText returns the state of the group and the added and removed users as mentions linked to their
+// pagerduty profile
+func changeSummaryText(summary ChangeSummary) string {
+	var lines []string
+	if summary.Disabled {
+		lines = append(lines, ":no_entry: nobody on shift, group disabled")
+	}
+	if summary.EmptyRotation == config.EmptyRotationFallback {
+		lines = append(lines, ":sos: nobody on shift, group set to the *fallback* members")
+	}
+	if summary.Enabled {
+		lines = append(lines, ":white_check_mark: group enabled again")
+	}
+	for _, u := range summary.Added {
+		lines = append(lines, "+ "+summaryUserText(u))
+	}
+	for _, u := range summary.Removed {
+		lines = append(lines, "− "+summaryUserText(u))
+	}
+	return fmt.Sprintf("*Changes:* `%d` member(s)\n%s", summary.Members, strings.Join(lines, "\n"))
+}
+
+func summaryUserText(u SummaryUser) string {
+	switch {
+	case u.PagerDutyURL == "":
+		return fmt.Sprintf("<@%s>", u.SlackID)
+	case u.MatchedBy != "" && u.MatchedBy != config.MatchEmail:
+		return fmt.Sprintf("<@%s> (<%s|PagerDuty>, matched by %s)", u.SlackID, u.PagerDutyURL, u.MatchedBy)
+	default:
+		return fmt.Sprintf("<@%s> (<%s|PagerDuty>)", u.SlackID, u.PagerDutyURL)
+	}
+}
+
+// observeGroupChange records the slack group metrics of a sync
+func observeGroupChange(j SyncJob, change slackclient.GroupChange) {
+	if j.Dryrun() {
+		metrics.ObserveGroupUpdate(j, len(change.Unchanged)+len(change.Removed), 0, 0)
+		return
+	}
+	metrics.ObserveGroupUpdate(j, len(change.Unchanged)+len(change.Added), len(change.Added), len(change.Removed))
+}
+
+// unmatchedPDUsers returns the pagerduty users without matching slack user
+func unmatchedPDUsers(pdUsers []pagerduty.User, matches []slackclient.Match) []pagerduty.User {
+	matched := make(map[string]struct{}, len(matches))
+	for _, m := range matches {
+		matched[m.PDUser.ID] = struct{}{}
+	}
 
-	if errorSection != nil {
-		return c.PostMessage(slack.MsgOptionBlocks(headerSection, errorSection, jobSection, divSection))
+	var unmatched []pagerduty.User
+	for _, u := range pdUsers {
+		if _, ok := matched[u.ID]; !ok {
+			unmatched = append(unmatched, u)
+		}
 	}
-	return c.PostMessage(slack.MsgOptionBlocks(headerSection, jobSection, divSection))
+	return unmatched
 }
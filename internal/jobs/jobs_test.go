@@ -0,0 +1,913 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slacktest"
+	"github.com/stretchr/testify/assert"
+
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/metrics"
+	"github.com/sapcc/pagerduty2slack/internal/retry"
+)
+
+// slackTestData is the workspace of the slack test server, group members are changed by usergroups.users.update
+type slackTestData struct {
+	mutex    sync.Mutex
+	users    []slack.User
+	groups   []slack.UserGroup
+	messages []string // texts of the sections of the posted messages
+	channels []string // the messages were posted to
+}
+
+func newSlackTestData() *slackTestData {
+	return &slackTestData{
+		users: []slack.User{
+			{ID: "W1", RealName: "Egon Spengler", Profile: slack.UserProfile{Email: "egon@example.com"}},
+			{ID: "W2", RealName: "Ray Stantz", Profile: slack.UserProfile{Email: "ray@example.com"}},
+			{ID: "W3", RealName: "Peter Venkman", Profile: slack.UserProfile{Email: "pv@example.com"}},
+			{ID: "W4", RealName: "Winston Zeddemore", Profile: slack.UserProfile{Email: "wz@example.com"}},
+		},
+		groups: []slack.UserGroup{
+			{ID: "S1", Handle: "backup", Name: "Backup", Users: []string{"W3", "W1"}, UserCount: 2},
+			{ID: "S2", Handle: "empty", Name: "Empty"},
+			{ID: "S3", Handle: "oncall", Name: "On Call", Users: []string{"W2"}, UserCount: 1},
+		},
+	}
+}
+
+// setupSlack returns a client of a slack test server serving the data
+func setupSlack(t *testing.T, data *slackTestData) *slackclient.Client {
+	server := slacktest.NewTestServer(func(s slacktest.Customize) {
+		s.Handle("/chat.postMessage", data.postMessageHandler)
+	})
+	server.Handle("/conversations.list", data.listConversationsHandler)
+	server.Handle("/users.list", data.listUsersHandler)
+	server.Handle("/usergroups.list", data.listUserGroupsHandler)
+	server.Handle("/usergroups.users.list", data.listUserGroupUsersHandler)
+	server.Handle("/usergroups.users.update", data.updateUserGroupUsersHandler)
+	go server.Start()
+	t.Cleanup(server.Stop)
+
+	cfg := &config.SlackConfig{
+		BotSecurityToken:  "TEST_TOKEN",
+		UserSecurityToken: "TEST_TOKEN",
+		InfoChannelID:     "general",
+		APIURL:            server.GetAPIURL(),
+	}
+	c, err := slackclient.NewClient(context.Background(), cfg, retry.Policy{MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("failed setting up slack test server: %s", err.Error())
+	}
+	return c
+}
+
+// group returns the members of the group with the handle
+func (sd *slackTestData) group(handle string) []string {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	for _, g := range sd.groups {
+		if g.Handle == handle {
+			return g.Users
+		}
+	}
+	return nil
+}
+
+// lastMessage returns the text of the last posted message
+func (sd *slackTestData) lastMessage() string {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	if len(sd.messages) == 0 {
+		return ""
+	}
+	return sd.messages[len(sd.messages)-1]
+}
+
+func (sd *slackTestData) listConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	channel := slack.Channel{}
+	channel.ID, channel.Name = "C1", "general"
+	writeJSON(w, struct {
+		Channels []slack.Channel `json:"channels"`
+		slack.SlackResponse
+	}{Channels: []slack.Channel{channel}, SlackResponse: slack.SlackResponse{Ok: true}})
+}
+
+func (sd *slackTestData) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, struct {
+		Users []slack.User `json:"members"`
+		slack.SlackResponse
+	}{Users: sd.users, SlackResponse: slack.SlackResponse{Ok: true}})
+}
+
+func (sd *slackTestData) listUserGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	writeJSON(w, struct {
+		UserGroups []slack.UserGroup `json:"usergroups"`
+		slack.SlackResponse
+	}{UserGroups: sd.groups, SlackResponse: slack.SlackResponse{Ok: true}})
+}
+
+func (sd *slackTestData) listUserGroupUsersHandler(w http.ResponseWriter, r *http.Request) {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	for _, g := range sd.groups {
+		if g.ID == r.FormValue("usergroup") {
+			writeJSON(w, struct {
+				Users []string `json:"users"`
+				slack.SlackResponse
+			}{Users: g.Users, SlackResponse: slack.SlackResponse{Ok: true}})
+			return
+		}
+	}
+	writeJSON(w, slack.SlackResponse{Error: "no_such_subteam"})
+}
+
+func (sd *slackTestData) updateUserGroupUsersHandler(w http.ResponseWriter, r *http.Request) {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	for i, g := range sd.groups {
+		if g.ID == r.FormValue("usergroup") {
+			g.Users = strings.Split(r.FormValue("users"), ",")
+			g.UserCount = len(g.Users)
+			sd.groups[i] = g
+			writeJSON(w, struct {
+				UserGroup slack.UserGroup `json:"usergroup"`
+				slack.SlackResponse
+			}{UserGroup: g, SlackResponse: slack.SlackResponse{Ok: true}})
+			return
+		}
+	}
+	writeJSON(w, slack.SlackResponse{Error: "no_such_subteam"})
+}
+
+func (sd *slackTestData) postMessageHandler(w http.ResponseWriter, r *http.Request) {
+	texts := []string{r.FormValue("text")}
+	var blocks slack.Blocks
+	if err := json.Unmarshal([]byte(r.FormValue("blocks")), &blocks); err == nil {
+		for _, b := range blocks.BlockSet {
+			section, ok := b.(*slack.SectionBlock)
+			if !ok {
+				continue
+			}
+			if section.Text != nil {
+				texts = append(texts, section.Text.Text)
+			}
+			for _, f := range section.Fields {
+				texts = append(texts, f.Text)
+			}
+		}
+	}
+	sd.mutex.Lock()
+	sd.messages = append(sd.messages, strings.TrimSpace(strings.Join(texts, "\n")))
+	sd.channels = append(sd.channels, r.FormValue("channel"))
+	sd.mutex.Unlock()
+	writeJSON(w, struct {
+		Channel   string `json:"channel"`
+		Timestamp string `json:"ts"`
+		slack.SlackResponse
+	}{Channel: r.FormValue("channel"), Timestamp: "1700000000.000100", SlackResponse: slack.SlackResponse{Ok: true}})
+}
+
+// setupPagerDuty returns a client of a pagerduty test server answering the paths with the responses as JSON
+func setupPagerDuty(t *testing.T, responses map[string]interface{}) *pagerdutyclient.Client {
+	if _, ok := responses["/users"]; !ok {
+		responses["/users"] = pagerduty.ListUsersResponse{Users: []pagerduty.User{pdUser("PAPI", "api@example.com")}}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":2100,"message":"Not Found"}}`))
+			return
+		}
+		writeJSON(w, response)
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := &config.PagerdutyConfig{AuthToken: "test", APIUser: "api@example.com", APIEndpoint: server.URL, UserCacheTTL: time.Hour}
+	c, err := pagerdutyclient.NewClient(context.Background(), cfg, retry.Policy{MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("failed setting up pagerduty test server: %s", err.Error())
+	}
+	return c
+}
+
+func pdUser(id, email string) pagerduty.User {
+	return pagerduty.User{
+		APIObject: pagerduty.APIObject{ID: id, HTMLURL: "https://example.pagerduty.com/users/" + id},
+		Name:      id,
+		Email:     email,
+	}
+}
+
+// escalationPolicyResponses returns the responses of an escalation policy with the users on call by level
+func escalationPolicyResponses(id string, onCalls map[uint]pagerduty.User) map[string]interface{} {
+	responses := map[string]interface{}{
+		"/escalation_policies/" + id: map[string]interface{}{
+			"escalation_policy": pagerduty.EscalationPolicy{APIObject: pagerduty.APIObject{ID: id, Summary: "Policy " + id}},
+		},
+	}
+	var list pagerduty.ListOnCallsResponse
+	for level, u := range onCalls {
+		list.OnCalls = append(list.OnCalls, pagerduty.OnCall{User: pagerduty.User{APIObject: pagerduty.APIObject{ID: u.ID}}, EscalationLevel: level})
+		responses["/users/"+u.ID] = map[string]interface{}{"user": u}
+	}
+	responses["/oncalls"] = list
+	return responses
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// infoMessageJob is a job with a fixed state to post its info message
+type infoMessageJob struct {
+	summary ChangeSummary
+	err     error
+}
+
+func (j *infoMessageJob) Run(context.Context) error { return nil }
+func (j *infoMessageJob) Name() string              { return "job: test" }
+func (j *infoMessageJob) Icon() string              { return ":calendar:" }
+func (j *infoMessageJob) JobType() string           { return string(PdScheduleSync) }
+func (j *infoMessageJob) SlackHandle() string       { return "oncall" }
+func (j *infoMessageJob) Dryrun() bool              { return false }
+func (j *infoMessageJob) Error() error              { return j.err }
+func (j *infoMessageJob) NextRun() time.Time        { return time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC) }
+
+func (j *infoMessageJob) PagerDutyObjects() []pagerduty.APIObject {
+	return []pagerduty.APIObject{{Summary: "Primary", HTMLURL: "https://example.pagerduty.com/schedules/PS1"}}
+}
+
+func (j *infoMessageJob) SlackInfoMessageBody() *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.MarkdownType, "*Who is on call:*", false, false)
+}
+
+func (j *infoMessageJob) Plan(context.Context) (*Plan, error) { return nil, nil }
+func (j *infoMessageJob) ChangeSummary() ChangeSummary        { return j.summary }
+func (j *infoMessageJob) InfoMessageOptions() config.InfoMessageOptions {
+	return config.InfoMessageOptions{}
+}
+
+func slackIDs(users []slack.User) []string {
+	var ids []string
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return ids
+}
+
+func TestFallbackUsers(t *testing.T) {
+	type testCase struct {
+		name     string
+		fallback config.Fallback
+		expected []string
+		err      string
+	}
+	testCases := []testCase{
+		{
+			name:     "slack user IDs",
+			fallback: config.Fallback{SlackUserIDs: []string{"W1"}},
+			expected: []string{"W1"},
+		},
+		{
+			name:     "emails",
+			fallback: config.Fallback{Emails: []string{"RAY@example.com"}},
+			expected: []string{"W2"},
+		},
+		{
+			name:     "slack group",
+			fallback: config.Fallback{SlackGroupHandle: "backup"},
+			expected: []string{"W3", "W1"},
+		},
+		{
+			name:     "first level of the escalation policy",
+			fallback: config.Fallback{EscalationPolicyID: "EP1"},
+			expected: []string{"W4"},
+		},
+		{
+			name: "all sources without duplicates",
+			fallback: config.Fallback{
+				SlackUserIDs:       []string{"W1", "W4"},
+				Emails:             []string{"egon@example.com"},
+				SlackGroupHandle:   "backup",
+				EscalationPolicyID: "EP1",
+			},
+			expected: []string{"W1", "W4", "W3"},
+		},
+		{
+			name:     "unknown slack user",
+			fallback: config.Fallback{SlackUserIDs: []string{"W9"}},
+			err:      "slack: user 'W9' not found",
+		},
+		{
+			name:     "empty slack group",
+			fallback: config.Fallback{SlackGroupHandle: "empty"},
+			err:      "job: fallback has no members",
+		},
+		{
+			name: "nothing configured",
+			err:  "job: fallback has no members",
+		},
+	}
+
+	c := setupSlack(t, newSlackTestData())
+	pd := setupPagerDuty(t, escalationPolicyResponses("EP1", map[uint]pagerduty.User{
+		1: pdUser("P4", "wz@example.com"),
+		2: pdUser("P2", "ray@example.com"),
+	}))
+	for _, test := range testCases {
+		users, err := fallbackUsers(context.Background(), pd, c, test.fallback)
+		if test.err != "" {
+			if assert.Error(t, err, test.name) {
+				assert.Contains(t, err.Error(), test.err, test.name)
+			}
+			continue
+		}
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, slackIDs(users), test.name)
+		}
+	}
+}
+
+func TestSyncGroupFallback(t *testing.T) {
+	type testCase struct {
+		name          string
+		onShift       []string
+		fallback      config.Fallback
+		members       []string
+		emptyRotation config.EmptyRotation
+		err           string
+		message       string
+	}
+	testCases := []testCase{
+		{
+			name:     "somebody on shift",
+			onShift:  []string{"W1"},
+			fallback: config.Fallback{SlackUserIDs: []string{"W3"}},
+			members:  []string{"W1"},
+			message:  "*Changes:* `1` member(s)\n+ <@W1>\n− <@W2>",
+		},
+		{
+			name:          "nobody on shift",
+			fallback:      config.Fallback{SlackUserIDs: []string{"W3"}, SlackGroupHandle: "backup"},
+			members:       []string{"W3", "W1"},
+			emptyRotation: config.EmptyRotationFallback,
+			message:       "*Changes:* `2` member(s)\n:sos: nobody on shift, group set to the *fallback* members\n+ <@W3>\n+ <@W1>\n− <@W2>",
+		},
+		{
+			name:          "nobody on shift, fallback already set",
+			fallback:      config.Fallback{Emails: []string{"ray@example.com"}},
+			members:       []string{"W2"},
+			emptyRotation: config.EmptyRotationFallback,
+			message:       ":calendar: PD Schedule > Slack Handle: `oncall` - :sos: nobody on shift, *fallback* members, `1` member(s), next run 06 May 24 09:00 UTC",
+		},
+		{
+			name:          "nobody on shift, fallback empty",
+			fallback:      config.Fallback{SlackGroupHandle: "empty"},
+			members:       []string{"W2"},
+			emptyRotation: config.EmptyRotationFallback,
+			err:           "job: fallback has no members",
+			message:       ":stop-sign: *Error:* job: fallback has no members",
+		},
+	}
+
+	for _, test := range testCases {
+		data := newSlackTestData()
+		c := setupSlack(t, data)
+		pd := setupPagerDuty(t, map[string]interface{}{})
+		onShift, err := c.UsersByID(test.onShift)
+		assert.NoError(t, err, test.name)
+
+		empty := config.EmptyRotationOptions{Behavior: config.EmptyRotationFallback, Fallback: test.fallback}
+		result, err := syncGroup(context.Background(), pd, c, "oncall", onShift, empty, false)
+		if test.err != "" {
+			if assert.Error(t, err, test.name) {
+				assert.Contains(t, err.Error(), test.err, test.name)
+			}
+		} else {
+			assert.NoError(t, err, test.name)
+		}
+		assert.Equal(t, test.emptyRotation, result.emptyRotation, test.name)
+		assert.Equal(t, test.members, data.group("oncall"), test.name)
+
+		job := &infoMessageJob{summary: newChangeSummary(result, nil), err: err}
+		assert.NoError(t, PostInfoMessage(context.Background(), c, job), test.name)
+		assert.Contains(t, data.lastMessage(), test.message, test.name)
+	}
+}
+
+func TestSummaryUserText(t *testing.T) {
+	type testCase struct {
+		name     string
+		user     SummaryUser
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name:     "unknown to pagerduty",
+			user:     SummaryUser{SlackID: "W1"},
+			expected: "<@W1>",
+		},
+		{
+			name:     "matched by email",
+			user:     SummaryUser{SlackID: "W1", PagerDutyURL: "https://example.pagerduty.com/users/P1", MatchedBy: config.MatchEmail},
+			expected: "<@W1> (<https://example.pagerduty.com/users/P1|PagerDuty>)",
+		},
+		{
+			name:     "matched by name",
+			user:     SummaryUser{SlackID: "W1", PagerDutyURL: "https://example.pagerduty.com/users/P1", MatchedBy: config.MatchName},
+			expected: "<@W1> (<https://example.pagerduty.com/users/P1|PagerDuty>, matched by name)",
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, summaryUserText(test.user), test.name)
+	}
+}
+
+func TestChangeSummaryText(t *testing.T) {
+	type testCase struct {
+		name     string
+		summary  ChangeSummary
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name:     "added only",
+			summary:  ChangeSummary{Added: []SummaryUser{{SlackID: "W1"}, {SlackID: "W2"}}, Members: 3},
+			expected: "*Changes:* `3` member(s)\n+ <@W1>\n+ <@W2>",
+		},
+		{
+			name:     "removed only",
+			summary:  ChangeSummary{Removed: []SummaryUser{{SlackID: "W1", PagerDutyURL: "https://example.pagerduty.com/users/P1"}}, Members: 1},
+			expected: "*Changes:* `1` member(s)\n− <@W1> (<https://example.pagerduty.com/users/P1|PagerDuty>)",
+		},
+		{
+			name:     "disabled",
+			summary:  ChangeSummary{Disabled: true, Members: 2},
+			expected: "*Changes:* `2` member(s)\n:no_entry: nobody on shift, group disabled",
+		},
+		{
+			name:     "enabled",
+			summary:  ChangeSummary{Enabled: true, Added: []SummaryUser{{SlackID: "W1"}}, Members: 1},
+			expected: "*Changes:* `1` member(s)\n:white_check_mark: group enabled again\n+ <@W1>",
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, changeSummaryText(test.summary), test.name)
+	}
+}
+
+func TestPostInfoMessage(t *testing.T) {
+	type testCase struct {
+		name     string
+		job      *infoMessageJob
+		expected string
+	}
+	header := ":calendar: PD Schedule > Slack Handle: `oncall`"
+	fields := "*PD Source*\n<https://example.pagerduty.com/schedules/PS1|Primary>\n*Who is on call:*\n:alarm_clock: *Next run:* 06 May 24 09:00 UTC"
+	testCases := []testCase{
+		{
+			name:     "no change",
+			job:      &infoMessageJob{summary: ChangeSummary{Members: 2}},
+			expected: header + " - no changes, `2` member(s), next run 06 May 24 09:00 UTC",
+		},
+		{
+			name: "no change with unmatched users",
+			job: &infoMessageJob{summary: ChangeSummary{
+				Members:   1,
+				Unmatched: []pagerduty.User{pdUser("P5", "")},
+			}},
+			expected: header + " - no changes, :warning: `1` without slack user, `1` member(s), next run 06 May 24 09:00 UTC",
+		},
+		{
+			name:     "added only",
+			job:      &infoMessageJob{summary: ChangeSummary{Added: []SummaryUser{{SlackID: "W1"}}, Members: 2}},
+			expected: header + "\n" + fields + "\n*Changes:* `2` member(s)\n+ <@W1>",
+		},
+		{
+			name:     "removed only",
+			job:      &infoMessageJob{summary: ChangeSummary{Removed: []SummaryUser{{SlackID: "W2"}}, Members: 1}},
+			expected: header + "\n" + fields + "\n*Changes:* `1` member(s)\n− <@W2>",
+		},
+		{
+			name:     "error",
+			job:      &infoMessageJob{summary: ChangeSummary{Members: 1}, err: errors.New("slack: retrieving slack group 'oncall' failed")},
+			expected: header + "\n:stop-sign: *Error:* slack: retrieving slack group 'oncall' failed\n" + fields,
+		},
+	}
+
+	data := newSlackTestData()
+	c := setupSlack(t, data)
+	for _, test := range testCases {
+		assert.NoError(t, PostInfoMessage(context.Background(), c, test.job), test.name)
+		assert.Equal(t, test.expected, data.lastMessage(), test.name)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	c := setupSlack(t, newSlackTestData())
+	pd := setupPagerDuty(t, map[string]interface{}{})
+	objects := config.SyncObjects{SlackGroupHandle: "oncall", PagerdutyObjectIDs: []string{"PX"}}
+	scheduleJob, err := NewScheduleSyncJob(config.PagerdutyScheduleOnDutyToSlackGroup{CrontabExpressionForRepetition: "0 * * * *", ObjectsToSync: objects}, false, pd, c)
+	assert.NoError(t, err)
+	teamJob, err := NewTeamSyncJob(config.PagerdutyTeamToSlackGroup{CrontabExpressionForRepetition: "0 * * * *", ObjectsToSync: objects}, false, pd, c)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		job    SyncJob
+		prefix string
+	}{
+		{scheduleJob, "job: sync of pd on call users for schedules 'PX' failed: "},
+		{teamJob, "job: sync of pd members for teams 'PX' failed: "},
+	}
+	for _, test := range tests {
+		// wrapped the same way by both job types, the class is still found
+		runErr := test.job.Run(context.Background())
+		_, planErr := test.job.Plan(context.Background())
+		for _, err := range []error{runErr, planErr} {
+			if assert.Error(t, err, test.job.Name()) {
+				assert.True(t, strings.HasPrefix(err.Error(), test.prefix), err.Error())
+				assert.Equal(t, metrics.ErrorClassPagerDuty, metrics.ErrorClass(err), test.job.Name())
+			}
+		}
+	}
+}
+
+func TestUnmatchedUsersOnFailedSync(t *testing.T) {
+	data := newSlackTestData()
+	c := setupSlack(t, data)
+	unmatched := pdUser("P5", "louis@example.com")
+	unmatched.Teams = []pagerduty.Team{{APIObject: pagerduty.APIObject{ID: "T1"}}}
+	responses := escalationPolicyResponses("EP1", map[uint]pagerduty.User{
+		1: pdUser("P1", "egon@example.com"),
+		2: unmatched,
+	})
+	responses["/teams/T1/members"] = pagerduty.ListTeamMembersResponse{Members: []pagerduty.Member{
+		{User: pagerduty.APIObject{ID: "P2"}, Role: "manager"},
+		{User: pagerduty.APIObject{ID: "P5"}, Role: "responder"},
+	}}
+	responses["/users/P2"] = map[string]interface{}{"user": pdUser("P2", "ray@example.com")}
+	pd := setupPagerDuty(t, responses)
+
+	job, err := NewEscalationPolicySyncJob(config.PagerdutyEscalationPolicyToSlackGroup{
+		CrontabExpressionForRepetition: "0 * * * *",
+		InformManagerIfUnmatched:       true,
+		ObjectsToSync:                  config.SyncObjects{SlackGroupHandle: "missing", PagerdutyObjectIDs: []string{"EP1"}},
+	}, false, pd, c)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the group doesn't exist, the sync fails
+	assert.Error(t, job.Run(context.Background()))
+	if assert.Len(t, job.ChangeSummary().Unmatched, 1) {
+		assert.Equal(t, "P5", job.ChangeSummary().Unmatched[0].ID)
+	}
+	assert.Equal(t, []string{"W2"}, data.channels)
+	assert.Contains(t, data.lastMessage(), "<https://example.pagerduty.com/users/P5|P5> in your PagerDuty team is not synced to Slack groups")
+
+	assert.NoError(t, PostInfoMessage(context.Background(), c, job))
+	assert.Contains(t, data.lastMessage(), ":warning: *Without slack user, not synced:* <https://example.pagerduty.com/users/P5|P5> (no Slack user found)")
+}
+
+// run with -race: the jobs read the master data and write the groups while it's reloaded
+func TestJobsConcurrentMasterDataReload(t *testing.T) {
+	data := newSlackTestData()
+	c := setupSlack(t, data)
+	responses := escalationPolicyResponses("EP1", map[uint]pagerduty.User{
+		1: pdUser("P1", "egon@example.com"),
+		2: pdUser("P2", "ray@example.com"),
+	})
+	responses["/schedules/PS1"] = map[string]interface{}{"schedule": pagerduty.Schedule{APIObject: pagerduty.APIObject{ID: "PS1", Summary: "Primary"}}}
+	responses["/teams/T1"] = map[string]interface{}{"team": pagerduty.Team{APIObject: pagerduty.APIObject{ID: "T1", Summary: "Ghostbusters"}}}
+	responses["/users"] = pagerduty.ListUsersResponse{Users: []pagerduty.User{
+		pdUser("PAPI", "api@example.com"),
+		pdUser("P3", "pv@example.com"),
+		pdUser("P4", "wz@example.com"),
+	}}
+	pd := setupPagerDuty(t, responses)
+
+	scheduleJob, err := NewScheduleSyncJob(config.PagerdutyScheduleOnDutyToSlackGroup{
+		CrontabExpressionForRepetition: "0 * * * *",
+		SyncOptions:                    config.ScheduleSyncOptions{SyncStyle: config.FinalLayer},
+		ObjectsToSync:                  config.SyncObjects{SlackGroupHandle: "oncall", PagerdutyObjectIDs: []string{"PS1"}},
+	}, false, pd, c)
+	assert.NoError(t, err)
+	teamJob, err := NewTeamSyncJob(config.PagerdutyTeamToSlackGroup{
+		CrontabExpressionForRepetition: "0 * * * *",
+		ObjectsToSync:                  config.SyncObjects{SlackGroupHandle: "backup", PagerdutyObjectIDs: []string{"T1"}},
+	}, false, pd, c)
+	assert.NoError(t, err)
+	policyJob, err := NewEscalationPolicySyncJob(config.PagerdutyEscalationPolicyToSlackGroup{
+		CrontabExpressionForRepetition: "0 * * * *",
+		ObjectsToSync:                  config.SyncObjects{SlackGroupHandle: "empty", PagerdutyObjectIDs: []string{"EP1"}},
+	}, false, pd, c)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for _, job := range []SyncJob{scheduleJob, teamJob, policyJob} {
+		wg.Add(1)
+		go func(job SyncJob) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				assert.NoError(t, job.Run(context.Background()), job.Name())
+				assert.NoError(t, PostInfoMessage(context.Background(), c, job), job.Name())
+			}
+		}(job)
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, c.LoadMasterData(context.Background()))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			for _, handle := range []string{"oncall", "backup", "empty"} {
+				_, err := c.RefreshGroup(context.Background(), handle)
+				assert.NoError(t, err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	assert.ElementsMatch(t, []string{"W1", "W2"}, data.group("oncall"))
+	assert.ElementsMatch(t, []string{"W3", "W4"}, data.group("backup"))
+	assert.ElementsMatch(t, []string{"W1", "W2"}, data.group("empty"))
+	for _, handle := range []string{"oncall", "backup", "empty"} {
+		group, err := c.GetSlackGroup(handle)
+		if assert.NoError(t, err) {
+			assert.ElementsMatch(t, data.group(handle), group.Users, handle)
+		}
+	}
+}
+
+func TestRenderPlans(t *testing.T) {
+	type testCase struct {
+		name     string
+		plans    []*Plan
+		expected string
+	}
+	egon := PlanUser{ID: "W1", Name: "Egon Spengler", Email: "egon@example.com", MatchedBy: config.MatchEmail}
+	ray := PlanUser{ID: "W2", Name: "Ray Stantz", Email: "ray@example.com"}
+	testCases := []testCase{
+		{
+			name:     "no plans",
+			expected: "Plan: 0 to add, 0 to remove in 0 of 0 group(s), 0 failed.\n",
+		},
+		{
+			name:     "no changes",
+			plans:    []*Plan{{SlackHandle: "oncall", JobType: "PD Schedule"}},
+			expected: "# slack group @oncall (PD Schedule)\n  no changes\n\nPlan: 0 to add, 0 to remove in 0 of 1 group(s), 0 failed.\n",
+		},
+		{
+			name:     "no changes with note",
+			plans:    []*Plan{{SlackHandle: "oncall", JobType: "PD Schedule", Note: "nobody on shift, members kept"}},
+			expected: "# slack group @oncall (PD Schedule)\n  nobody on shift, members kept\n\nPlan: 0 to add, 0 to remove in 0 of 1 group(s), 0 failed.\n",
+		},
+		{
+			name:  "added and removed",
+			plans: []*Plan{{SlackHandle: "oncall", JobType: "PD Schedule", Add: []PlanUser{egon}, Remove: []PlanUser{ray}}},
+			expected: "# slack group @oncall (PD Schedule)\n" +
+				"  + Egon Spengler <egon@example.com> [W1]\n" +
+				"  - Ray Stantz <ray@example.com> [W2]\n\n" +
+				"Plan: 1 to add, 1 to remove in 1 of 1 group(s), 0 failed.\n",
+		},
+		{
+			name: "matched by name, without email and unknown user",
+			plans: []*Plan{{
+				SlackHandle: "oncall",
+				JobType:     "PD Team",
+				Note:        "group enabled again",
+				Add:         []PlanUser{{ID: "W3", Name: "Peter Venkman", MatchedBy: config.MatchName}},
+				Remove:      []PlanUser{{ID: "W9"}},
+			}},
+			expected: "# slack group @oncall (PD Team)\n" +
+				"  group enabled again\n" +
+				"  + Peter Venkman [W3] (matched by name)\n" +
+				"  - unknown user [W9]\n\n" +
+				"Plan: 1 to add, 1 to remove in 1 of 1 group(s), 0 failed.\n",
+		},
+		{
+			name: "failed",
+			plans: []*Plan{
+				{SlackHandle: "oncall", JobType: "PD Schedule", Add: []PlanUser{egon}},
+				{SlackHandle: "backup", JobType: "PD Team", Error: "pagerduty: team not found"},
+			},
+			expected: "# slack group @oncall (PD Schedule)\n  + Egon Spengler <egon@example.com> [W1]\n\n" +
+				"# slack group @backup (PD Team)\n  ! pagerduty: team not found\n\n" +
+				"Plan: 1 to add, 0 to remove in 1 of 2 group(s), 1 failed.\n",
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, RenderPlans(test.plans), test.name)
+	}
+}
+
+func TestPlanJSON(t *testing.T) {
+	type testCase struct {
+		name     string
+		plan     *Plan
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name:     "no changes",
+			plan:     &Plan{Job: "job: test", JobType: "PD Schedule", SlackHandle: "oncall", Add: []PlanUser{}, Remove: []PlanUser{}},
+			expected: `{"job":"job: test","type":"PD Schedule","slackHandle":"oncall","add":[],"remove":[]}`,
+		},
+		{
+			name: "added and unknown user removed",
+			plan: &Plan{
+				Job:         "job: test",
+				JobType:     "PD Schedule",
+				SlackHandle: "oncall",
+				Add:         []PlanUser{{ID: "W1", Name: "Egon Spengler", Email: "egon@example.com", MatchedBy: config.MatchEmail}},
+				Remove:      []PlanUser{{ID: "W9"}},
+				Note:        "group enabled again",
+			},
+			expected: `{"job":"job: test","type":"PD Schedule","slackHandle":"oncall",` +
+				`"add":[{"id":"W1","name":"Egon Spengler","email":"egon@example.com","matchedBy":"email"}],` +
+				`"remove":[{"id":"W9","name":""}],"note":"group enabled again"}`,
+		},
+		{
+			name:     "failed",
+			plan:     NewFailedPlan(&infoMessageJob{}, errors.New("pagerduty: team not found")),
+			expected: `{"job":"job: test","type":"PD Schedule","slackHandle":"oncall","add":[],"remove":[],"error":"pagerduty: team not found"}`,
+		},
+	}
+
+	for _, test := range testCases {
+		b, err := json.Marshal(test.plan)
+		if assert.NoError(t, err, test.name) {
+			assert.JSONEq(t, test.expected, string(b), test.name)
+		}
+	}
+}
+
+func TestPlanGroupChange(t *testing.T) {
+	type testCase struct {
+		name    string
+		matched []string
+		members []string
+		empty   config.EmptyRotationOptions
+		add     []PlanUser
+		remove  []PlanUser
+		note    string
+	}
+	egon := PlanUser{ID: "W1", Name: "Egon Spengler", Email: "egon@example.com", MatchedBy: config.MatchEmail}
+	ray := PlanUser{ID: "W2", Name: "Ray Stantz", Email: "ray@example.com"}
+	testCases := []testCase{
+		{
+			name:    "no changes",
+			matched: []string{"W2"},
+			members: []string{"W2"},
+			add:     []PlanUser{},
+			remove:  []PlanUser{},
+		},
+		{
+			name:    "added and removed with name and email",
+			matched: []string{"W1"},
+			members: []string{"W2"},
+			add:     []PlanUser{egon},
+			remove:  []PlanUser{ray},
+		},
+		{
+			name:    "member unknown to slack",
+			matched: []string{"W2"},
+			members: []string{"W2", "W9"},
+			add:     []PlanUser{},
+			remove:  []PlanUser{{ID: "W9"}},
+		},
+		{
+			name:    "nobody on shift",
+			members: []string{"W2"},
+			add:     []PlanUser{},
+			remove:  []PlanUser{},
+			note:    "nobody on shift, members kept",
+		},
+		{
+			name:    "nobody on shift with fallback",
+			members: []string{"W2"},
+			empty:   config.EmptyRotationOptions{Behavior: config.EmptyRotationFallback, Fallback: config.Fallback{SlackUserIDs: []string{"W3"}}},
+			add:     []PlanUser{{ID: "W3", Name: "Peter Venkman", Email: "pv@example.com"}},
+			remove:  []PlanUser{ray},
+			note:    "nobody on shift, fallback members",
+		},
+	}
+
+	for _, test := range testCases {
+		data := newSlackTestData()
+		data.groups[2].Users = test.members
+		c := setupSlack(t, data)
+		pd := setupPagerDuty(t, map[string]interface{}{})
+		users, err := c.UsersByID(test.matched)
+		assert.NoError(t, err, test.name)
+		var matches []slackclient.Match
+		for _, u := range users {
+			matches = append(matches, slackclient.Match{SlackUser: u, Strategy: config.MatchEmail})
+		}
+
+		plan, err := planGroupChange(context.Background(), &infoMessageJob{}, pd, c, matches, test.empty)
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+		assert.Equal(t, "oncall", plan.SlackHandle, test.name)
+		assert.Equal(t, test.add, plan.Add, test.name)
+		assert.Equal(t, test.remove, plan.Remove, test.name)
+		assert.Equal(t, test.note, plan.Note, test.name)
+		assert.Equal(t, test.members, data.group("oncall"), "planning doesn't change the group: "+test.name)
+	}
+}
+
+func TestEscalationPolicySyncJob(t *testing.T) {
+	users := map[string]pagerduty.User{
+		"P1": pdUser("P1", "egon@example.com"),
+		"P2": pdUser("P2", "ray@example.com"),
+		"P3": pdUser("P3", "pv@example.com"),
+	}
+	responses := map[string]interface{}{
+		"/escalation_policies/EP1": map[string]interface{}{
+			"escalation_policy": pagerduty.EscalationPolicy{APIObject: pagerduty.APIObject{ID: "EP1", Summary: "Policy EP1"}},
+		},
+	}
+	var onCalls pagerduty.ListOnCallsResponse
+	for _, o := range []struct {
+		user  string
+		level uint
+	}{{"P1", 1}, {"P2", 2}, {"P1", 3}, {"P3", 3}} {
+		onCalls.OnCalls = append(onCalls.OnCalls, pagerduty.OnCall{User: pagerduty.User{APIObject: pagerduty.APIObject{ID: o.user}}, EscalationLevel: o.level})
+	}
+	responses["/oncalls"] = onCalls
+	for id, u := range users {
+		u.Summary = "User " + id
+		responses["/users/"+id] = map[string]interface{}{"user": u}
+	}
+
+	type testCase struct {
+		name     string
+		levels   []uint
+		members  []string
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name:    "selected levels",
+			levels:  []uint{1, 3},
+			members: []string{"W1", "W3"},
+			expected: "*Who is on call:*\n" +
+				" - <https://example.pagerduty.com/users/P1|User P1> (L1, L3),\n" +
+				" - <https://example.pagerduty.com/users/P3|User P3> (L3)",
+		},
+		{
+			name:    "all levels",
+			members: []string{"W1", "W2", "W3"},
+			expected: "*Who is on call:*\n" +
+				" - <https://example.pagerduty.com/users/P1|User P1> (L1, L3),\n" +
+				" - <https://example.pagerduty.com/users/P2|User P2> (L2),\n" +
+				" - <https://example.pagerduty.com/users/P3|User P3> (L3)",
+		},
+	}
+
+	for _, test := range testCases {
+		data := newSlackTestData()
+		c := setupSlack(t, data)
+		pd := setupPagerDuty(t, responses)
+		job, err := NewEscalationPolicySyncJob(config.PagerdutyEscalationPolicyToSlackGroup{
+			CrontabExpressionForRepetition: "0 * * * *",
+			EscalationLevels:               test.levels,
+			ObjectsToSync:                  config.SyncObjects{SlackGroupHandle: "oncall", PagerdutyObjectIDs: []string{"EP1"}},
+		}, false, pd, c)
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+
+		assert.NoError(t, job.Run(context.Background()), test.name)
+		assert.ElementsMatch(t, test.members, data.group("oncall"), test.name)
+		assert.Equal(t, test.expected, job.SlackInfoMessageBody().Text, test.name)
+		assert.Equal(t, []pagerduty.APIObject{{ID: "EP1", Summary: "Policy EP1"}}, job.PagerDutyObjects(), test.name)
+		assert.Empty(t, job.ChangeSummary().Unmatched, test.name)
+	}
+}
@@ -0,0 +1,52 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PagerDuty/go-pagerduty"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+)
+
+// missingPhoneTopic identifies the direct message asking for a phone contact method
+const missingPhoneTopic = "missing-phone"
+
+// informUsersWithoutPhone asks the users without phone contact method by direct message to add one. A user gets the
+// message at most once per direct message interval. It returns the users without phone contact method.
+func informUsersWithoutPhone(ctx context.Context, pd *pagerdutyclient.Client, c *slackclient.Client, pdUsers []pagerduty.User, dryrun bool) []pagerduty.User {
+	var withoutPhone []pagerduty.User
+	seen := make(map[string]struct{})
+	for _, u := range pd.WithoutPhone(pdUsers) {
+		if _, ok := seen[u.ID]; ok {
+			continue
+		}
+		seen[u.ID] = struct{}{}
+		withoutPhone = append(withoutPhone, u)
+
+		slackUsers, err := c.MatchPDUsers(ctx, []pagerduty.User{u})
+		if err != nil || len(slackUsers) == 0 {
+			log.Infof("job: pagerduty user without phone %s %s has no slack user to inform", u.Name, u.HTMLURL)
+			continue
+		}
+		if dryrun {
+			log.Infof("job: dry run. would inform %s about the missing phone contact method", u.Name)
+			continue
+		}
+
+		text := fmt.Sprintf(":telephone_receiver: Hi %s, your <%s|PagerDuty profile> has no phone contact method. "+
+			"Please add one, so you can be called while you are on call.", u.Name, u.HTMLURL)
+		sent, err := c.SendDirectMessage(ctx, slackUsers[0].ID, missingPhoneTopic, slack.MsgOptionText(text, false))
+		if err != nil {
+			log.Warnf("job: informing %s about the missing phone contact method failed: %s", u.Name, err.Error())
+			continue
+		}
+		if sent {
+			log.Infof("job: informed %s about the missing phone contact method", u.Name)
+		}
+	}
+	return withoutPhone
+}
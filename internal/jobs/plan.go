@@ -0,0 +1,154 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/config"
+)
+
+// PlanUser is a slack user which would be added to or removed from a group
+type PlanUser struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	// strategy which matched the pagerduty user, empty for removed and fallback users
+	MatchedBy config.MatchStrategy `json:"matchedBy,omitempty"`
+}
+
+// Plan describes the changes a job would apply to its slack user group
+type Plan struct {
+	Job         string     `json:"job"`
+	JobType     string     `json:"type"`
+	SlackHandle string     `json:"slackHandle"`
+	Add         []PlanUser `json:"add"`
+	Remove      []PlanUser `json:"remove"`
+	Note        string     `json:"note,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// HasChanges is true when users would be added or removed
+func (p *Plan) HasChanges() bool {
+	return len(p.Add) > 0 || len(p.Remove) > 0
+}
+
+// NewFailedPlan returns a plan for a job which could not be planned
+func NewFailedPlan(j SyncJob, err error) *Plan {
+	return &Plan{Job: j.Name(), JobType: j.JobType(), SlackHandle: j.SlackHandle(), Add: []PlanUser{}, Remove: []PlanUser{}, Error: err.Error()}
+}
+
+// planGroupChange returns the plan setting the matched slack users as members of the job's slack group, applying the
+// empty rotation behavior if there are none
+func planGroupChange(ctx context.Context, j SyncJob, pd *pagerdutyclient.Client, c *slackclient.Client, matches []slackclient.Match, empty config.EmptyRotationOptions) (*Plan, error) {
+	p := &Plan{Job: j.Name(), JobType: j.JobType(), SlackHandle: j.SlackHandle(), Add: []PlanUser{}, Remove: []PlanUser{}}
+	slackUsers := slackclient.SlackUsers(matches)
+	if len(slackUsers) == 0 {
+		switch empty.Behavior {
+		case config.EmptyRotationFallback:
+			fallback, err := fallbackUsers(ctx, pd, c, empty.Fallback)
+			if err != nil {
+				return nil, err
+			}
+			slackUsers = fallback
+			p.Note = "nobody on shift, fallback members"
+		case config.EmptyRotationDisable:
+			p.Note = "nobody on shift, group disabled"
+		default:
+			p.Note = "nobody on shift, members kept"
+		}
+	}
+	if len(slackUsers) == 0 {
+		if _, err := c.GetSlackGroup(j.SlackHandle()); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	change, err := c.DiffGroup(j.SlackHandle(), slackUsers)
+	if err != nil {
+		return nil, err
+	}
+	if change.Enabled {
+		if p.Note != "" {
+			p.Note += ", "
+		}
+		p.Note += "group enabled again"
+	}
+
+	strategies := make(map[string]config.MatchStrategy, len(matches))
+	for _, m := range matches {
+		strategies[m.SlackUser.ID] = m.Strategy
+	}
+	for _, u := range change.Added {
+		pu := newPlanUser(u)
+		pu.MatchedBy = strategies[u.ID]
+		p.Add = append(p.Add, pu)
+	}
+	for _, u := range change.Removed {
+		p.Remove = append(p.Remove, newPlanUser(u))
+	}
+	return p, nil
+}
+
+func newPlanUser(u slack.User) PlanUser {
+	name := u.RealName
+	if name == "" {
+		name = u.Name
+	}
+	return PlanUser{ID: u.ID, Name: name, Email: u.Profile.Email}
+}
+
+// RenderPlans returns a diff like text representation of the plans
+func RenderPlans(plans []*Plan) string {
+	var sb strings.Builder
+	add, remove, changed, failed := 0, 0, 0, 0
+	for _, p := range plans {
+		fmt.Fprintf(&sb, "# slack group @%s (%s)\n", p.SlackHandle, p.JobType)
+		switch {
+		case p.Error != "":
+			failed++
+			fmt.Fprintf(&sb, "  ! %s\n", p.Error)
+		case p.Note != "" && !p.HasChanges():
+			fmt.Fprintf(&sb, "  %s\n", p.Note)
+		case !p.HasChanges():
+			sb.WriteString("  no changes\n")
+		default:
+			changed++
+			if p.Note != "" {
+				fmt.Fprintf(&sb, "  %s\n", p.Note)
+			}
+			for _, u := range p.Add {
+				fmt.Fprintf(&sb, "  + %s\n", u)
+			}
+			for _, u := range p.Remove {
+				fmt.Fprintf(&sb, "  - %s\n", u)
+			}
+		}
+		add += len(p.Add)
+		remove += len(p.Remove)
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "Plan: %d to add, %d to remove in %d of %d group(s), %d failed.\n", add, remove, changed, len(plans), failed)
+	return sb.String()
+}
+
+// String renders the user as `name <email> [id]`, followed by the strategy if not matched by email. Users missing in
+// the slack master data are rendered as `unknown user [id]`.
+func (u PlanUser) String() string {
+	name := u.Name
+	if name == "" {
+		name = "unknown user"
+	}
+	s := fmt.Sprintf("%s <%s> [%s]", name, u.Email, u.ID)
+	if u.Email == "" {
+		s = fmt.Sprintf("%s [%s]", name, u.ID)
+	}
+	if u.MatchedBy != "" && u.MatchedBy != config.MatchEmail {
+		s += fmt.Sprintf(" (matched by %s)", u.MatchedBy)
+	}
+	return s
+}
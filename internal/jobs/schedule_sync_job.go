@@ -1,6 +1,7 @@
 package jobs
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -13,13 +14,20 @@ import (
 	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
 	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
 	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/metrics"
 )
 
 type PagerdutyScheduleToSlackJob struct {
-	syncOpts config.ScheduleSyncOptions // options for tasks during sync
-	schedule cron.Schedule              // on which this job runs
-	dryrun   bool                       // when enabled changes are not manifested
-	err      error                      // err used for slack info message
+	syncOpts           config.ScheduleSyncOptions  // options for tasks during sync
+	schedule           cron.Schedule               // on which this job runs
+	location           *time.Location              // time zone of the schedule
+	dryrun             bool                        // when enabled changes are not manifested
+	err                error                       // err used for slack info message
+	result             syncResult                  // of the last run
+	emptyRotation      config.EmptyRotationOptions // behavior if nobody is on shift
+	informMissingPhone bool                        // inform users without phone contact method
+	informManager      bool                        // inform the team managers of users without slack user
+	infoMessage        config.InfoMessageOptions   // where the info messages are posted
 
 	pd          *pagerdutyclient.Client // pagerduty API access
 	slackClient *slackclient.Client     // slack API access
@@ -28,66 +36,86 @@ type PagerdutyScheduleToSlackJob struct {
 	// TODO: get pagerdutySchedules when creating the Job?
 	pagerDutyIDs       []string              // IDs of the schedules to sync
 	pagerdutyUsers     []pagerduty.User      // users part of the pagerduty schedule(s)
+	matches            []slackclient.Match   // of the pagerduty users to slack users
 	pagerdutySchedules []pagerduty.APIObject // pagerduty schedules synced by this job
 }
 
 // NewSchedulesSyncJob creates a new job to sync members of pagerduty schedules to a slack user group
 func NewScheduleSyncJob(cfg config.PagerdutyScheduleOnDutyToSlackGroup, dryrun bool, pd *pagerdutyclient.Client, slackClient *slackclient.Client) (*PagerdutyScheduleToSlackJob, error) {
-	schedule, err := cron.ParseStandard("TZ=UTC " + cfg.CrontabExpressionForRepetition)
+	schedule, err := ParseSchedule(cfg.CrontabExpressionForRepetition, cfg.Timezone)
 	if err != nil {
-		return nil, fmt.Errorf("job: invalid cron schedule '%s': %w", cfg.CrontabExpressionForRepetition, err)
+		return nil, err
 	}
 	return &PagerdutyScheduleToSlackJob{
-		syncOpts:     cfg.SyncOptions,
-		dryrun:       dryrun,
-		slackHandle:  cfg.ObjectsToSync.SlackGroupHandle,
-		pagerDutyIDs: cfg.ObjectsToSync.PagerdutyObjectIDs,
-		schedule:     schedule,
-		pd:           pd,
-		slackClient:  slackClient,
+		syncOpts:           cfg.SyncOptions,
+		dryrun:             dryrun,
+		slackHandle:        cfg.ObjectsToSync.SlackGroupHandle,
+		pagerDutyIDs:       cfg.ObjectsToSync.PagerdutyObjectIDs,
+		schedule:           schedule,
+		location:           cfg.Timezone.Location(),
+		pd:                 pd,
+		slackClient:        slackClient,
+		infoMessage:        cfg.InfoMessage,
+		emptyRotation:      cfg.EmptyRotation,
+		informMissingPhone: cfg.CheckUserContactForPhoneSet || cfg.SyncOptions.InformUserIfContactPhoneNumberMissing,
+		informManager:      cfg.InformManagerIfUnmatched,
 	}, nil
 }
 
 // Run syncs pagerduty schedule members to slack user group
-func (s *PagerdutyScheduleToSlackJob) Run() error {
+func (s *PagerdutyScheduleToSlackJob) Run(ctx context.Context) error {
 	log.Info(s.Name())
 	s.err = nil
+	s.result = syncResult{}
 
-	tfF, err := time.ParseDuration(s.syncOpts.HandoverTimeFrameForward)
+	slackUsers, err := s.resolveSlackUsers(ctx)
 	if err != nil {
-		tfF = time.Nanosecond * 0
-		eS := fmt.Sprintf("job: invalid timeframe forward duration '%s' for sync of '%s'. Use default 0.:%s", s.syncOpts.HandoverTimeFrameBackward, s.slackHandle, err.Error())
-		s.err = fmt.Errorf(eS)
+		s.err = err
+		return fmt.Errorf("job: sync of pd on call users for schedules '%s' failed: %w", strings.Join(s.pagerDutyIDs, ","), err)
 	}
-	tfB, err := time.ParseDuration(s.syncOpts.HandoverTimeFrameBackward)
-	if err != nil {
-		tfB = time.Nanosecond * 0
-		eS := fmt.Sprintf("job: invalid timeframe backward duration '%s' for sync of '%s'. Use default 0.:%s", s.syncOpts.HandoverTimeFrameBackward, s.slackHandle, err.Error())
-		log.Warn(eS)
-		log.Info(eS)
+	unmatched := unmatchedPDUsers(s.pagerdutyUsers, s.matches)
+	metrics.SetUnmatchedUsers(s, len(unmatched))
+	// reported even if the sync fails, the users are missing in the group anyway
+	s.result.unmatched = unmatched
+	if s.informManager && len(unmatched) > 0 {
+		informTeamManagers(ctx, s.pd, s.slackClient, unmatched, s.dryrun)
 	}
 
-	pdUsers, pdSchedules, err := s.pd.ListOnCallUsers(s.pagerDutyIDs, tfF, tfB, s.syncOpts.SyncStyle)
+	// put ldap users which also have a slack account to our slack group (who's not in the ldap group is out)
+	result, err := syncGroup(ctx, s.pd, s.slackClient, s.slackHandle, slackUsers, s.emptyRotation, s.dryrun)
+	result.unmatched = unmatched
 	if err != nil {
 		s.err = err
-		return err
+		return fmt.Errorf("job: adding OnDuty members to slack group %s failed: %w", s.slackHandle, err)
 	}
-	s.pagerdutyUsers = pdUsers
-	s.pagerdutySchedules = pdSchedules
+	if s.informMissingPhone {
+		result.withoutPhone = informUsersWithoutPhone(ctx, s.pd, s.slackClient, s.pagerdutyUsers, s.dryrun)
+	}
+	s.result = result
+	observeGroupChange(s, result.change)
+	return nil
+}
 
-	// get all SLACK users, bcz. we need the SLACK user id and match them with the ldap users
-	slackUsers, err := s.slackClient.MatchPDUsers(pdUsers)
-	if err != nil {
-		s.err = err
-		return err
+// Plan returns the changes Run would apply to the slack user group
+func (s *PagerdutyScheduleToSlackJob) Plan(ctx context.Context) (*Plan, error) {
+	if _, err := s.resolveSlackUsers(ctx); err != nil {
+		return nil, fmt.Errorf("job: sync of pd on call users for schedules '%s' failed: %w", strings.Join(s.pagerDutyIDs, ","), err)
 	}
+	return planGroupChange(ctx, s, s.pd, s.slackClient, s.matches, s.emptyRotation)
+}
 
-	// put ldap users which also have a slack account to our slack group (who's not in the ldap group is out)
-	if _, err = s.slackClient.AddToGroup(s.slackHandle, slackUsers, s.dryrun); err != nil {
-		s.err = err
-		return fmt.Errorf("job: adding OnDuty members to slack group %s failed: %w", s.slackHandle, err)
+// resolveSlackUsers returns the slack users of the pagerduty users on shift
+func (s *PagerdutyScheduleToSlackJob) resolveSlackUsers(ctx context.Context) ([]slack.User, error) {
+	pdUsers, pdSchedules, err := s.pd.ListOnCallUsers(ctx, s.pagerDutyIDs, s.syncOpts.HandoverTimeFrameForward, s.syncOpts.HandoverTimeFrameBackward, s.syncOpts.SyncStyle)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	s.pagerdutyUsers = pdUsers
+	s.pagerdutySchedules = pdSchedules
+
+	// get all SLACK users, bcz. we need the SLACK user id and match them with the ldap users
+	s.matches, err = s.slackClient.Match(ctx, pdUsers)
+	return slackclient.SlackUsers(s.matches), err
 }
 
 // Name of the job
@@ -137,10 +165,20 @@ func (s *PagerdutyScheduleToSlackJob) Dryrun() bool {
 
 // NextRun returns the time from now when the cron is next executed
 func (s *PagerdutyScheduleToSlackJob) NextRun() time.Time {
-	return s.schedule.Next(time.Now())
+	return s.schedule.Next(time.Now()).In(s.location)
 }
 
 // Error if any occurred during the sync
 func (s *PagerdutyScheduleToSlackJob) Error() error {
 	return s.err
 }
+
+// ChangeSummary returns the users added to and removed from the slack user group by the last run
+func (s *PagerdutyScheduleToSlackJob) ChangeSummary() ChangeSummary {
+	return newChangeSummary(s.result, s.matches)
+}
+
+// InfoMessageOptions returns where the info messages are posted
+func (s *PagerdutyScheduleToSlackJob) InfoMessageOptions() config.InfoMessageOptions {
+	return s.infoMessage
+}
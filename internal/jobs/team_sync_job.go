@@ -1,6 +1,7 @@
 package jobs
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -13,13 +14,19 @@ import (
 	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
 	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
 	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/metrics"
 )
 
 type PagerdutyTeamToSlackJob struct {
-	syncOpts *config.ScheduleSyncOptions // options for tasks during sync
-	schedule cron.Schedule               // on which this job runs
-	dryrun   bool                        // when enabled changes are not manifested
-	err      error                       // err used for slack info message
+	schedule           cron.Schedule               // on which this job runs
+	location           *time.Location              // time zone of the schedule
+	dryrun             bool                        // when enabled changes are not manifested
+	err                error                       // err used for slack info message
+	result             syncResult                  // of the last run
+	emptyRotation      config.EmptyRotationOptions // behavior if nobody is on shift
+	informMissingPhone bool                        // inform users without phone contact method
+	informManager      bool                        // inform the team managers of users without slack user
+	infoMessage        config.InfoMessageOptions   // where the info messages are posted
 
 	pd          *pagerdutyclient.Client // pagerduty API access
 	slackClient *slackclient.Client     // slack API access
@@ -28,63 +35,85 @@ type PagerdutyTeamToSlackJob struct {
 	// TODO: get pagerdutyTeams when creating the Job?
 	pagerDutyIDs   []string              // IDs of the team(s) to sync
 	pagerDutyUsers []pagerduty.User      // users part of the pagerduty team(s)
+	matches        []slackclient.Match   // of the pagerduty users to slack users
 	pagerDutyTeams []pagerduty.APIObject // pagerduty team(s) synced by this job
 }
 
 // NewSchedulesSyncJob creates a new job to sync members of pagerduty teams to a slack user group
 func NewTeamSyncJob(cfg config.PagerdutyTeamToSlackGroup, dryrun bool, pd *pagerdutyclient.Client, slackClient *slackclient.Client) (*PagerdutyTeamToSlackJob, error) {
-	schedule, err := cron.ParseStandard("TZ=UTC " + cfg.CrontabExpressionForRepetition)
+	schedule, err := ParseSchedule(cfg.CrontabExpressionForRepetition, cfg.Timezone)
 	if err != nil {
-		return nil, fmt.Errorf("job: invalid cron schedule '%s': %w", cfg.CrontabExpressionForRepetition, err)
+		return nil, err
 	}
 	return &PagerdutyTeamToSlackJob{
-		schedule:     schedule,
-		slackHandle:  cfg.ObjectsToSync.SlackGroupHandle,
-		pagerDutyIDs: cfg.ObjectsToSync.PagerdutyObjectIDs,
-		pd:           pd,
-		slackClient:  slackClient,
-		dryrun:       dryrun,
+		schedule:           schedule,
+		location:           cfg.Timezone.Location(),
+		slackHandle:        cfg.ObjectsToSync.SlackGroupHandle,
+		pagerDutyIDs:       cfg.ObjectsToSync.PagerdutyObjectIDs,
+		pd:                 pd,
+		slackClient:        slackClient,
+		infoMessage:        cfg.InfoMessage,
+		emptyRotation:      cfg.EmptyRotation,
+		informMissingPhone: cfg.CheckUserContactForPhoneSet,
+		informManager:      cfg.InformManagerIfUnmatched,
+		dryrun:             dryrun,
 	}, nil
 }
 
 // Run syncs pagerduty team(s) members to slack user group
-func (t *PagerdutyTeamToSlackJob) Run() error {
+func (t *PagerdutyTeamToSlackJob) Run(ctx context.Context) error {
 	log.Info(t.Name())
 	t.err = nil
+	t.result = syncResult{}
 
-	// find members of given group
-	pdUsers, pdTeams, err := t.pd.TeamMembers(t.pagerDutyIDs)
+	slackUserFilteredList, err := t.resolveSlackUsers(ctx)
 	if err != nil {
 		t.err = err
 		return fmt.Errorf("job: sync of pd members for teams '%s' failed: %w", strings.Join(t.pagerDutyIDs, ","), err)
 	}
-	t.pagerDutyTeams = pdTeams
-	t.pagerDutyUsers = pdUsers
-
-	pdUsersWithoutPhone := t.pd.WithoutPhone(pdUsers)
-	for _, i2 := range pdUsersWithoutPhone {
-		log.Infof("job: team_sync: pagerduty user without Fon: %s %s", i2.Name, i2.HTMLURL)
+	unmatched := unmatchedPDUsers(t.pagerDutyUsers, t.matches)
+	metrics.SetUnmatchedUsers(t, len(unmatched))
+	// reported even if the sync fails, the users are missing in the group anyway
+	t.result.unmatched = unmatched
+	if t.informManager && len(unmatched) > 0 {
+		informTeamManagers(ctx, t.pd, t.slackClient, unmatched, t.dryrun)
 	}
 
-	// get all SLACK users, bcz. we need the SLACK user id and match them with the ldap users
-	slackUserFilteredList, err := t.slackClient.MatchPDUsers(pdUsers)
+	result, err := syncGroup(ctx, t.pd, t.slackClient, t.slackHandle, slackUserFilteredList, t.emptyRotation, t.dryrun)
+	result.unmatched = unmatched
 	if err != nil {
 		t.err = err
-		return fmt.Errorf("job: sync of pd members for teams '%s' failed: %w", strings.Join(t.pagerDutyIDs, ","), err)
+		return fmt.Errorf("job: updating slack group '%s' failed: %s", t.slackHandle, err.Error())
+	}
+	if t.informMissingPhone {
+		result.withoutPhone = informUsersWithoutPhone(ctx, t.pd, t.slackClient, t.pagerDutyUsers, t.dryrun)
 	}
+	t.result = result
+	observeGroupChange(t, result.change)
+	return nil
+}
 
-	if len(slackUserFilteredList) == 0 && t.syncOpts.DisableSlackHandleTemporaryIfNoneOnShift {
-		if err := t.slackClient.DisableGroup(t.slackHandle); err != nil {
-			t.err = err
-			return err
-		}
+// Plan returns the changes Run would apply to the slack user group
+func (t *PagerdutyTeamToSlackJob) Plan(ctx context.Context) (*Plan, error) {
+	if _, err := t.resolveSlackUsers(ctx); err != nil {
+		return nil, fmt.Errorf("job: sync of pd members for teams '%s' failed: %w", strings.Join(t.pagerDutyIDs, ","), err)
 	}
+	return planGroupChange(ctx, t, t.pd, t.slackClient, t.matches, t.emptyRotation)
+}
 
-	if _, err := t.slackClient.AddToGroup(t.slackHandle, slackUserFilteredList, t.dryrun); err != nil {
-		t.err = err
-		return fmt.Errorf("job: updating slack group '%s' failed: %s", t.slackHandle, err.Error())
+// resolveSlackUsers returns the slack users of the pagerduty team members
+func (t *PagerdutyTeamToSlackJob) resolveSlackUsers(ctx context.Context) ([]slack.User, error) {
+	// find members of given group
+	pdUsers, pdTeams, err := t.pd.TeamMembers(ctx, t.pagerDutyIDs)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	t.pagerDutyTeams = pdTeams
+	t.pagerDutyUsers = pdUsers
+
+	// get all SLACK users, bcz. we need the SLACK user id and match them with the ldap users
+	t.matches, err = t.slackClient.Match(ctx, pdUsers)
+	return slackclient.SlackUsers(t.matches), err
 }
 
 // Name of the job
@@ -134,10 +163,20 @@ func (t *PagerdutyTeamToSlackJob) Dryrun() bool {
 
 // NextRun returns the time from now when the cron is next executed
 func (t *PagerdutyTeamToSlackJob) NextRun() time.Time {
-	return t.schedule.Next(time.Now())
+	return t.schedule.Next(time.Now()).In(t.location)
 }
 
 // Error if any occurred during the sync
 func (t *PagerdutyTeamToSlackJob) Error() error {
 	return t.err
 }
+
+// ChangeSummary returns the users added to and removed from the slack user group by the last run
+func (t *PagerdutyTeamToSlackJob) ChangeSummary() ChangeSummary {
+	return newChangeSummary(t.result, t.matches)
+}
+
+// InfoMessageOptions returns where the info messages are posted
+func (t *PagerdutyTeamToSlackJob) InfoMessageOptions() config.InfoMessageOptions {
+	return t.infoMessage
+}
@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PagerDuty/go-pagerduty"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+)
+
+// unmatchedTopic identifies the direct message about a pagerduty user without slack user, followed by the user ID
+const unmatchedTopic = "unmatched/"
+
+// unmatchedReason returns why the pagerduty user has no slack user
+func unmatchedReason(u pagerduty.User) string {
+	if u.Email == "" {
+		return "no email in PagerDuty"
+	}
+	return "no Slack user found"
+}
+
+// informTeamManagers tells the managers of the pagerduty teams of the unmatched users by direct message that the
+// users are not synced. A manager gets the message about a user at most once per direct message interval.
+func informTeamManagers(ctx context.Context, pd *pagerdutyclient.Client, c *slackclient.Client, unmatched []pagerduty.User, dryrun bool) {
+	managersByTeam := make(map[string][]slack.User)
+	for _, u := range unmatched {
+		var managers []slack.User
+		for _, team := range u.Teams {
+			teamManagers, ok := managersByTeam[team.ID]
+			if !ok {
+				pdManagers, err := pd.TeamManagers(ctx, []string{team.ID})
+				if err != nil {
+					log.Warnf("job: %s", err.Error())
+					continue
+				}
+				if len(pdManagers) > 0 {
+					if teamManagers, err = c.MatchPDUsers(ctx, pdManagers); err != nil {
+						log.Warnf("job: %s", err.Error())
+						continue
+					}
+				}
+				managersByTeam[team.ID] = teamManagers
+			}
+			managers = append(managers, teamManagers...)
+		}
+		if len(managers) == 0 {
+			log.Infof("job: pagerduty user without slack user %s %s has no team manager to inform", u.Name, u.HTMLURL)
+			continue
+		}
+		if dryrun {
+			log.Infof("job: dry run. would inform the team managers of %s about the missing slack user", u.Name)
+			continue
+		}
+
+		text := fmt.Sprintf(":warning: Hi, <%s|%s> in your PagerDuty team is not synced to Slack groups (%s). "+
+			"Please make sure the email in PagerDuty matches the Slack account.", u.HTMLURL, u.Name, unmatchedReason(u))
+		informed := make(map[string]struct{})
+		for _, m := range managers {
+			if _, ok := informed[m.ID]; ok {
+				continue
+			}
+			informed[m.ID] = struct{}{}
+			if _, err := c.SendDirectMessage(ctx, m.ID, unmatchedTopic+u.ID, slack.MsgOptionText(text, false)); err != nil {
+				log.Warnf("job: informing the team manager about %s failed: %s", u.Name, err.Error())
+			}
+		}
+	}
+}
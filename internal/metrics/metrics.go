@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	pd "github.com/PagerDuty/go-pagerduty"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	slackgo "github.com/slack-go/slack"
+)
+
+const namespace = "pagerduty2slack"
+
+// error classes used as label for failed job runs
+const (
+	ErrorClassPagerDuty   = "pagerduty"
+	ErrorClassSlack       = "slack"
+	ErrorClassRateLimited = "rate_limited"
+	ErrorClassOther       = "other"
+)
+
+// Job is the part of a sync job used to label its metrics
+type Job interface {
+	// Name of the job
+	Name() string
+	// JobType as string
+	JobType() string
+	// SlackHandle of the slack user group
+	SlackHandle() string
+}
+
+var jobLabels = []string{"job", "type", "slack_handle"}
+
+var (
+	jobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_runs_total",
+		Help:      "Number of sync job runs.",
+	}, jobLabels)
+	jobFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_failures_total",
+		Help:      "Number of failed sync job runs by error class.",
+	}, append(jobLabels, "error_class"))
+	jobLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful sync job run.",
+	}, jobLabels)
+	slackGroupMembers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slack_group_members",
+		Help:      "Number of members in the slack user group after the last sync.",
+	}, jobLabels)
+	slackGroupUsersAdded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "slack_group_users_added_total",
+		Help:      "Number of users added to the slack user group.",
+	}, jobLabels)
+	slackGroupUsersRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "slack_group_users_removed_total",
+		Help:      "Number of users removed from the slack user group.",
+	}, jobLabels)
+	pagerdutyUnmatchedUsers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pagerduty_unmatched_users",
+		Help:      "Number of pagerduty users without matching slack user in the last sync.",
+	}, jobLabels)
+	pagerdutyUserCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pagerduty_user_cache_lookups_total",
+		Help:      "Number of pagerduty user cache lookups by result (hit or miss).",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		jobRuns,
+		jobFailures,
+		jobLastSuccess,
+		slackGroupMembers,
+		slackGroupUsersAdded,
+		slackGroupUsersRemoved,
+		pagerdutyUnmatchedUsers,
+		pagerdutyUserCacheLookups,
+	)
+}
+
+// labels returns the label values for the given job
+func labels(j Job) prometheus.Labels {
+	return prometheus.Labels{
+		"job":          j.Name(),
+		"type":         j.JobType(),
+		"slack_handle": j.SlackHandle(),
+	}
+}
+
+// ObserveJobRun counts a run of the job and records either the failure or the time of success
+func ObserveJobRun(j Job, err error) {
+	l := labels(j)
+	jobRuns.With(l).Inc()
+	if err != nil {
+		l["error_class"] = ErrorClass(err)
+		jobFailures.With(l).Inc()
+		return
+	}
+	jobLastSuccess.With(l).Set(float64(time.Now().Unix()))
+}
+
+// ObserveGroupUpdate records the member count and the number of added and removed users of the slack group
+func ObserveGroupUpdate(j Job, members, added, removed int) {
+	l := labels(j)
+	slackGroupMembers.With(l).Set(float64(members))
+	slackGroupUsersAdded.With(l).Add(float64(added))
+	slackGroupUsersRemoved.With(l).Add(float64(removed))
+}
+
+// SetUnmatchedUsers records the number of pagerduty users without slack account
+func SetUnmatchedUsers(j Job, count int) {
+	pagerdutyUnmatchedUsers.With(labels(j)).Set(float64(count))
+}
+
+// DeleteJob removes all series of the job, e.g. when it was removed from the config
+func DeleteJob(j Job) {
+	l := labels(j)
+	jobRuns.Delete(l)
+	jobFailures.DeletePartialMatch(l)
+	jobLastSuccess.Delete(l)
+	slackGroupMembers.Delete(l)
+	slackGroupUsersAdded.Delete(l)
+	slackGroupUsersRemoved.Delete(l)
+	pagerdutyUnmatchedUsers.Delete(l)
+}
+
+// ObserveUserCacheLookup counts a lookup of the pagerduty user cache
+func ObserveUserCacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	pagerdutyUserCacheLookups.WithLabelValues(result).Inc()
+}
+
+// ErrorClass returns the class of the given error used to label failures
+func ErrorClass(err error) string {
+	var pdErr pd.APIError
+	if errors.As(err, &pdErr) {
+		if pdErr.RateLimited() {
+			return ErrorClassRateLimited
+		}
+		return ErrorClassPagerDuty
+	}
+
+	var rateLimitErr *slackgo.RateLimitedError
+	if errors.As(err, &rateLimitErr) {
+		return ErrorClassRateLimited
+	}
+	var slackErr slackgo.SlackErrorResponse
+	var statusErr slackgo.StatusCodeError
+	if errors.As(err, &slackErr) || errors.As(err, &statusErr) {
+		return ErrorClassSlack
+	}
+
+	return ErrorClassOther
+}
+
+// RegisterHandlers adds the metrics endpoint `/metrics` and an index page to the mux
+func RegisterHandlers(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(
+			`<html>
+			<head><title>pagerduty2slack</title></head>
+			<body>
+			<h1>pagerduty2slack</h1>
+			<p><a href="/metrics">Metrics</a></p>
+			<p><a href="/healthz">Health</a> <a href="/readyz">Readiness</a></p>
+			<p><a href="https://github.com/sapcc/pagerduty2slack">Git Repository</a></p>
+			</body>
+			</html>`))
+	})
+}
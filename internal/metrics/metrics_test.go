@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	pd "github.com/PagerDuty/go-pagerduty"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	slackgo "github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClass(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected string
+	}{
+		{err: pd.APIError{StatusCode: http.StatusNotFound}, expected: ErrorClassPagerDuty},
+		{err: pd.APIError{StatusCode: http.StatusTooManyRequests}, expected: ErrorClassRateLimited},
+		{err: fmt.Errorf("job: failed: %w", pd.APIError{StatusCode: http.StatusBadGateway}), expected: ErrorClassPagerDuty},
+		{err: fmt.Errorf("slack: failed: %w", slackgo.SlackErrorResponse{Err: "no_such_subteam"}), expected: ErrorClassSlack},
+		{err: fmt.Errorf("slack: failed: %w", &slackgo.RateLimitedError{}), expected: ErrorClassRateLimited},
+		{err: fmt.Errorf("empty PD user list; check shift schedule"), expected: ErrorClassOther},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, ErrorClass(test.err), test.err.Error())
+	}
+}
+
+type testJob struct {
+	name string
+}
+
+func (j testJob) Name() string        { return j.name }
+func (j testJob) JobType() string     { return "PD Schedule" }
+func (j testJob) SlackHandle() string { return "oncall" }
+
+func TestDeleteJob(t *testing.T) {
+	removed, kept := testJob{name: "removed"}, testJob{name: "kept"}
+	for _, j := range []Job{removed, kept} {
+		ObserveJobRun(j, nil)
+		ObserveJobRun(j, pd.APIError{StatusCode: http.StatusNotFound})
+		ObserveJobRun(j, fmt.Errorf("failed"))
+		ObserveGroupUpdate(j, 2, 1, 1)
+		SetUnmatchedUsers(j, 1)
+	}
+
+	DeleteJob(removed)
+
+	assert.Equal(t, 1, testutil.CollectAndCount(jobRuns))
+	assert.Equal(t, 2, testutil.CollectAndCount(jobFailures))
+	assert.Equal(t, 1, testutil.CollectAndCount(jobLastSuccess))
+	assert.Equal(t, 1, testutil.CollectAndCount(slackGroupMembers))
+	assert.Equal(t, 1, testutil.CollectAndCount(slackGroupUsersAdded))
+	assert.Equal(t, 1, testutil.CollectAndCount(slackGroupUsersRemoved))
+	assert.Equal(t, 1, testutil.CollectAndCount(pagerdutyUnmatchedUsers))
+	assert.Equal(t, 3.0, testutil.ToFloat64(jobRuns.With(labels(kept))))
+}
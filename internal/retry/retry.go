@@ -0,0 +1,173 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sapcc/pagerduty2slack/internal/config"
+)
+
+// Policy defines how often and how long rate limited or failed api requests are retried
+type Policy struct {
+	MaxAttempts int           // attempts including the first one
+	BaseDelay   time.Duration // delay before the first retry, doubled for every further retry
+	MaxDelay    time.Duration // upper bound of the backoff delay
+}
+
+// NewPolicy returns the retry policy of the global config
+func NewPolicy(cfg config.GlobalConfig) Policy {
+	return Policy{
+		MaxAttempts: cfg.Retry.MaxAttempts,
+		BaseDelay:   cfg.Retry.BaseDelay,
+		MaxDelay:    cfg.Retry.MaxDelay,
+	}
+}
+
+// Doer executes http requests, e.g. a *http.Client
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client retries requests answered with 429 or 5xx or failed by transport errors. Requests which must not be sent
+// twice, like posting a message, are only retried on 429 since they may have been processed otherwise.
+// The wait time of the Retry-After or ratelimit-reset header is honored, otherwise
+// it backs off exponentially with jitter. No retry is started if it would end after the deadline of the request
+// context, i.e. the job deadline.
+type Client struct {
+	policy Policy
+	next   Doer
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
+	jitter func() float64
+}
+
+// NewClient returns a client retrying the requests of next according to the policy
+func NewClient(next Doer, policy Policy) *Client {
+	return &Client{
+		policy: policy,
+		next:   next,
+		now:    time.Now,
+		sleep:  sleep,
+		jitter: rand.Float64, //nolint:gosec // jitter needs no secure random numbers
+	}
+}
+
+// Do executes the request and retries it if it's retryable
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.next.Do(req)
+		reason, retryable := retryableResult(req, resp, err)
+		if !retryable || attempt >= c.policy.MaxAttempts || !replayable(req) {
+			return resp, err
+		}
+
+		wait := retryAfter(resp)
+		if wait == 0 {
+			wait = c.backoff(attempt)
+		}
+		if deadline, ok := req.Context().Deadline(); ok && c.now().Add(wait).After(deadline) {
+			log.Warnf("retry: %s %s: %s, not retrying since waiting %s exceeds the deadline", req.Method, req.URL.Path, reason, wait)
+			return resp, err
+		}
+		if resp != nil {
+			// drained to reuse the connection, errors don't matter for a discarded response
+			_, _ = io.Copy(io.Discard, resp.Body) //nolint:errcheck // see above
+			resp.Body.Close()                     //nolint:errcheck // see above
+		}
+		log.Warnf("retry: %s %s: %s, retrying in %s (attempt %d of %d)", req.Method, req.URL.Path, reason, wait, attempt+1, c.policy.MaxAttempts)
+
+		if err := c.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("retry: resetting request body failed: %w", err)
+			}
+			req.Body = body
+		}
+	}
+}
+
+// replayable returns whether the request body can be sent again
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// backoff returns the exponential delay for the attempt with jitter
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := float64(c.policy.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if delay > float64(c.policy.MaxDelay) {
+		delay = float64(c.policy.MaxDelay)
+	}
+	// wait between half and the full delay, so concurrent jobs don't retry at once
+	return time.Duration(delay/2 + c.jitter()*delay/2)
+}
+
+// nonIdempotent are the slack api methods which create something on every call, all slack methods are POSTs
+var nonIdempotent = map[string]bool{
+	"chat.postMessage": true,
+	"pins.add":         true,
+}
+
+// idempotent returns whether sending the request again has no further effect
+func idempotent(req *http.Request) bool {
+	return !nonIdempotent[path.Base(req.URL.Path)]
+}
+
+// retryableResult returns whether the result of a request is worth a retry and why
+func retryableResult(req *http.Request, resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		return err.Error(), idempotent(req)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return resp.Status, true
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return resp.Status, idempotent(req)
+	}
+	return "", false
+}
+
+// retryAfter returns the wait time requested by the response, 0 if none
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	// slack sends Retry-After, pagerduty the seconds until the rate limit resets
+	for _, header := range []string{"Retry-After", "ratelimit-reset"} {
+		v := resp.Header.Get(header)
+		if v == "" {
+			continue
+		}
+		if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d
+			}
+		}
+	}
+	return 0
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,193 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type doerMock struct {
+	responses []*http.Response
+	bodies    []string
+}
+
+func (m *doerMock) Do(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body) //nolint:errcheck // body is compared by the test
+		m.bodies = append(m.bodies, string(b))
+	}
+	resp := m.responses[0]
+	m.responses = m.responses[1:]
+	return resp, nil
+}
+
+func response(status int, header ...string) *http.Response {
+	h := http.Header{}
+	for i := 0; i+1 < len(header); i += 2 {
+		h.Set(header[i], header[i+1])
+	}
+	return &http.Response{StatusCode: status, Status: http.StatusText(status), Header: h, Body: io.NopCloser(strings.NewReader(""))}
+}
+
+func setupClient(mock *doerMock, policy Policy) (client *Client, waits *[]time.Duration) {
+	waits = &[]time.Duration{}
+	now := time.Now()
+	client = NewClient(mock, policy)
+	client.now = func() time.Time { return now }
+	client.jitter = func() float64 { return 1 }
+	client.sleep = func(_ context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		now = now.Add(d)
+		return nil
+	}
+	return client, waits
+}
+
+var testPolicy = Policy{MaxAttempts: 4, BaseDelay: time.Second, MaxDelay: 3 * time.Second}
+
+func TestRetryBackoff(t *testing.T) {
+	mock := &doerMock{responses: []*http.Response{
+		response(http.StatusBadGateway),
+		response(http.StatusServiceUnavailable),
+		response(http.StatusInternalServerError),
+		response(http.StatusOK),
+	}}
+	client, waits := setupClient(mock, testPolicy)
+
+	req, _ := http.NewRequest(http.MethodPost, "https://slack.com/api/usergroups.users.update", strings.NewReader("users=U1,U2")) //nolint:errcheck // valid request
+	resp, err := client.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, *waits)
+	assert.Equal(t, []string{"users=U1,U2", "users=U1,U2", "users=U1,U2", "users=U1,U2"}, mock.bodies)
+}
+
+func TestRetryAfter(t *testing.T) {
+	mock := &doerMock{responses: []*http.Response{
+		response(http.StatusTooManyRequests, "Retry-After", "30"),
+		response(http.StatusTooManyRequests, "ratelimit-reset", "7"),
+		response(http.StatusOK),
+	}}
+	client, waits := setupClient(mock, testPolicy)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.pagerduty.com/schedules/1", http.NoBody) //nolint:errcheck // valid request
+	resp, err := client.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []time.Duration{30 * time.Second, 7 * time.Second}, *waits)
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	type testCase struct {
+		name      string
+		responses []*http.Response
+		expected  int
+		waits     int
+	}
+	testCases := []testCase{
+		{
+			name:      "client error",
+			responses: []*http.Response{response(http.StatusNotFound)},
+			expected:  http.StatusNotFound,
+		},
+		{
+			name:      "max attempts",
+			responses: []*http.Response{response(http.StatusBadGateway), response(http.StatusBadGateway), response(http.StatusBadGateway), response(http.StatusBadGateway)},
+			expected:  http.StatusBadGateway,
+			waits:     3,
+		},
+		{
+			name:      "deadline",
+			responses: []*http.Response{response(http.StatusTooManyRequests, "Retry-After", "50"), response(http.StatusTooManyRequests, "Retry-After", "50")},
+			expected:  http.StatusTooManyRequests,
+			waits:     1,
+		},
+	}
+
+	for _, test := range testCases {
+		client, waits := setupClient(&doerMock{responses: test.responses}, testPolicy)
+		// e.g. the job deadline
+		ctx, cancel := context.WithDeadline(context.Background(), client.now().Add(time.Minute))
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.pagerduty.com/users", http.NoBody) //nolint:errcheck // valid request
+		resp, err := client.Do(req)
+		cancel()
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, resp.StatusCode, test.name)
+		assert.Equal(t, test.waits, len(*waits), test.name)
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	type testCase struct {
+		name      string
+		responses []*http.Response
+		expected  int
+		waits     int
+	}
+	testCases := []testCase{
+		{
+			name:      "server error",
+			responses: []*http.Response{response(http.StatusBadGateway)},
+			expected:  http.StatusBadGateway,
+		},
+		{
+			name:      "rate limited",
+			responses: []*http.Response{response(http.StatusTooManyRequests, "Retry-After", "1"), response(http.StatusOK)},
+			expected:  http.StatusOK,
+			waits:     1,
+		},
+	}
+
+	for _, test := range testCases {
+		mock := &doerMock{responses: test.responses}
+		client, waits := setupClient(mock, testPolicy)
+
+		body := "channel=C123&text=hello"
+		req, _ := http.NewRequest(http.MethodPost, "https://slack.com/api/chat.postMessage", strings.NewReader(body)) //nolint:errcheck // valid request
+		resp, err := client.Do(req)
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, resp.StatusCode, test.name)
+		assert.Equal(t, test.waits, len(*waits), test.name)
+		assert.Len(t, mock.bodies, test.waits+1, test.name)
+		assert.Empty(t, mock.responses, test.name)
+	}
+}
+
+func TestRetryContextDeadline(t *testing.T) {
+	mock := &doerMock{responses: []*http.Response{
+		response(http.StatusTooManyRequests, "Retry-After", "20"),
+		response(http.StatusOK),
+	}}
+	client, waits := setupClient(mock, testPolicy)
+
+	ctx, cancel := context.WithDeadline(context.Background(), client.now().Add(10*time.Second))
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.pagerduty.com/users", http.NoBody) //nolint:errcheck // valid request
+	resp, err := client.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, 0, len(*waits))
+}
+
+func TestRetryCancelled(t *testing.T) {
+	mock := &doerMock{responses: []*http.Response{response(http.StatusBadGateway)}}
+	client := NewClient(mock, testPolicy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.pagerduty.com/users", http.NoBody) //nolint:errcheck // valid request
+	_, err := client.Do(req)
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,334 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	pagerdutyclient "github.com/sapcc/pagerduty2slack/internal/clients/pagerduty"
+	slackclient "github.com/sapcc/pagerduty2slack/internal/clients/slack"
+	"github.com/sapcc/pagerduty2slack/internal/config"
+	"github.com/sapcc/pagerduty2slack/internal/jobs"
+)
+
+// Severity of a finding
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+const (
+	scheduleSyncKey = "pd-schedules-on-duty-to-slack-group"
+	teamSyncKey     = "pd-teams-to-slack-group"
+	policySyncKey   = "pd-escalation-policies-to-slack-group"
+)
+
+// Finding describes a single problem in the configuration
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Job      string   `json:"job,omitempty"`
+	Field    string   `json:"field,omitempty"`
+	Line     int      `json:"line,omitempty"` // in the YAML file, 0 if unknown
+	Message  string   `json:"message"`
+}
+
+// Report collects all findings of a validation
+type Report struct {
+	ConfigFile string    `json:"configFile"`
+	Online     bool      `json:"online"`
+	Jobs       int       `json:"jobs"`
+	Findings   []Finding `json:"findings"`
+}
+
+// HasErrors returns true if any finding is an error
+func (r *Report) HasErrors() bool {
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the report human readable
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "config: %s (%d job(s), online: %v)\n", r.ConfigFile, r.Jobs, r.Online)
+	for _, f := range r.Findings {
+		fmt.Fprintf(&sb, "  %-7s %s", f.Severity, f.Job)
+		if f.Field != "" {
+			fmt.Fprintf(&sb, " %s", f.Field)
+		}
+		if f.Line > 0 {
+			fmt.Fprintf(&sb, " (line %d)", f.Line)
+		}
+		fmt.Fprintf(&sb, ": %s\n", f.Message)
+	}
+	if r.HasErrors() {
+		sb.WriteString("result: invalid\n")
+	} else {
+		sb.WriteString("result: valid\n")
+	}
+	return sb.String()
+}
+
+func (r *Report) add(severity Severity, job, field, format string, args ...any) {
+	r.addAt(severity, job, field, 0, format, args...)
+}
+
+func (r *Report) addAt(severity Severity, job, field string, line int, format string, args ...any) {
+	r.Findings = append(r.Findings, Finding{Severity: severity, Job: job, Field: field, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// jobLine returns the line of the field of the job in the YAML file, the one of the job if the field is not set
+func jobLine(cfg *config.Config, job, field string) int {
+	if line := cfg.Line("jobs." + job + "." + field); line > 0 {
+		return line
+	}
+	return cfg.Line("jobs." + job)
+}
+
+// FromError returns a report with the problems found decoding the configuration
+func FromError(configFile string, err error) *Report {
+	r := &Report{ConfigFile: configFile, Findings: []Finding{}}
+	var vErr *config.ValidationError
+	if !errors.As(err, &vErr) {
+		r.add(SeverityError, "", "", "%s", err.Error())
+		return r
+	}
+	for _, fe := range vErr.Errors {
+		field := ""
+		if fe.Line > 0 {
+			field = fmt.Sprintf("line %d", fe.Line)
+		}
+		r.add(SeverityError, fe.Job, field, "%s", fe.Message)
+	}
+	return r
+}
+
+// Offline checks the configuration without accessing any API.
+// Unknown keys, durations and sync styles are already checked reading the configuration.
+func Offline(cfg *config.Config) *Report {
+	r := &Report{ConfigFile: cfg.ConfigFilePath, Jobs: len(cfg.Jobs.ScheduleSync) + len(cfg.Jobs.TeamSync) + len(cfg.Jobs.PolicySync), Findings: []Finding{}}
+
+	if r.Jobs == 0 {
+		r.add(SeverityWarning, "", "jobs", "no jobs configured")
+	}
+	if expression := cfg.Global.DigestCrontabExpression; expression != "" {
+		if _, err := jobs.ParseSchedule(expression, cfg.Global.Timezone); err != nil {
+			r.add(SeverityError, "global", "digestCrontabExpression", "%s", err.Error())
+		}
+	}
+
+	checkUserMatching(r, cfg.Slack.UserMatching)
+
+	handles := make(map[string]string)
+	checkObjects := func(job string, o config.SyncObjects) {
+		if o.SlackGroupHandle == "" {
+			r.add(SeverityError, job, "syncObjects.slackGroupHandle", "not set")
+		} else {
+			h := strings.ToLower(o.SlackGroupHandle)
+			if other, ok := handles[h]; ok {
+				r.add(SeverityError, job, "syncObjects.slackGroupHandle", "'%s' is already synced by %s", o.SlackGroupHandle, other)
+			} else {
+				handles[h] = job
+			}
+		}
+		if len(o.PagerdutyObjectIDs) == 0 {
+			r.add(SeverityError, job, "syncObjects.pdObjectIds", "not set")
+		}
+	}
+
+	for i, s := range cfg.Jobs.ScheduleSync {
+		job := fmt.Sprintf("%s[%d]", scheduleSyncKey, i)
+		checkCrontab(r, cfg, job, s.CrontabExpressionForRepetition, s.Timezone)
+		checkHandover(r, cfg, job, s)
+		checkObjects(job, s.ObjectsToSync)
+		checkEmptyRotation(r, job, s.EmptyRotation)
+	}
+	for i, t := range cfg.Jobs.TeamSync {
+		job := fmt.Sprintf("%s[%d]", teamSyncKey, i)
+		checkCrontab(r, cfg, job, t.CrontabExpressionForRepetition, t.Timezone)
+		checkObjects(job, t.ObjectsToSync)
+		checkEmptyRotation(r, job, t.EmptyRotation)
+	}
+	for i, e := range cfg.Jobs.PolicySync {
+		job := fmt.Sprintf("%s[%d]", policySyncKey, i)
+		checkCrontab(r, cfg, job, e.CrontabExpressionForRepetition, e.Timezone)
+		for _, l := range e.EscalationLevels {
+			if l == 0 {
+				r.add(SeverityError, job, "escalationLevels", "escalation levels start at 1")
+			}
+		}
+		checkObjects(job, e.ObjectsToSync)
+		checkEmptyRotation(r, job, e.EmptyRotation)
+	}
+	return r
+}
+
+// Online checks all pagerduty objects and slack handles of the configuration against the APIs
+func Online(ctx context.Context, r *Report, cfg *config.Config, pd *pagerdutyclient.Client, slackClient *slackclient.Client) {
+	r.Online = true
+
+	checkHandle := func(job, handle string) {
+		if handle == "" {
+			return
+		}
+		if _, err := slackClient.GetSlackGroup(handle); err != nil {
+			r.add(SeverityError, job, "syncObjects.slackGroupHandle", "%s", err.Error())
+		}
+	}
+	checkFallback := func(job string, f config.Fallback) {
+		if _, err := slackClient.UsersByID(f.SlackUserIDs); err != nil {
+			r.add(SeverityError, job, "fallback.slackUserIds", "%s", err.Error())
+		}
+		if _, err := slackClient.UsersByEmail(f.Emails); err != nil {
+			r.add(SeverityError, job, "fallback.emails", "%s", err.Error())
+		}
+		if f.SlackGroupHandle != "" {
+			if _, err := slackClient.GetSlackGroup(f.SlackGroupHandle); err != nil {
+				r.add(SeverityError, job, "fallback.slackGroupHandle", "%s", err.Error())
+			}
+		}
+		if f.EscalationPolicyID != "" {
+			if _, err := pd.GetEscalationPolicy(ctx, f.EscalationPolicyID); err != nil {
+				r.add(SeverityError, job, "fallback.pdEscalationPolicyId", "%s", err.Error())
+			}
+		}
+	}
+	checkChannel := func(job string, opts config.InfoMessageOptions) {
+		if opts.Channel == "" {
+			return
+		}
+		if _, err := slackClient.GetChannel(opts.Channel); err != nil {
+			r.add(SeverityError, job, "infoChannel", "%s", err.Error())
+		}
+	}
+
+	for i, s := range cfg.Jobs.ScheduleSync {
+		job := fmt.Sprintf("%s[%d]", scheduleSyncKey, i)
+		for _, id := range s.ObjectsToSync.PagerdutyObjectIDs {
+			if _, err := pd.GetSchedule(ctx, id); err != nil {
+				r.add(SeverityError, job, "syncObjects.pdObjectIds", "%s", err.Error())
+			}
+		}
+		checkHandle(job, s.ObjectsToSync.SlackGroupHandle)
+		checkChannel(job, s.InfoMessage)
+		checkFallback(job, s.EmptyRotation.Fallback)
+	}
+	for i, t := range cfg.Jobs.TeamSync {
+		job := fmt.Sprintf("%s[%d]", teamSyncKey, i)
+		for _, id := range t.ObjectsToSync.PagerdutyObjectIDs {
+			if _, err := pd.GetTeam(ctx, id); err != nil {
+				r.add(SeverityError, job, "syncObjects.pdObjectIds", "%s", err.Error())
+			}
+		}
+		checkHandle(job, t.ObjectsToSync.SlackGroupHandle)
+		checkChannel(job, t.InfoMessage)
+		checkFallback(job, t.EmptyRotation.Fallback)
+	}
+	for i, e := range cfg.Jobs.PolicySync {
+		job := fmt.Sprintf("%s[%d]", policySyncKey, i)
+		for _, id := range e.ObjectsToSync.PagerdutyObjectIDs {
+			if _, err := pd.GetEscalationPolicy(ctx, id); err != nil {
+				r.add(SeverityError, job, "syncObjects.pdObjectIds", "%s", err.Error())
+			}
+		}
+		checkHandle(job, e.ObjectsToSync.SlackGroupHandle)
+		checkChannel(job, e.InfoMessage)
+		checkFallback(job, e.EmptyRotation.Fallback)
+	}
+}
+
+func checkCrontab(r *Report, cfg *config.Config, job, expression string, tz config.Timezone) {
+	field := "crontabExpressionForRepetition"
+	line := jobLine(cfg, job, field)
+	if expression == "" {
+		r.addAt(SeverityError, job, field, line, "not set")
+		return
+	}
+	if _, err := jobs.ParseSchedule(expression, tz); err != nil {
+		r.addAt(SeverityError, job, field, line, "%s", err.Error())
+	}
+}
+
+// checkHandover checks that the handover durations are not negative and the time frame is neither empty nor longer
+// than the interval of the job, so the users on shift are synced as intended
+func checkHandover(r *Report, cfg *config.Config, job string, s config.PagerdutyScheduleOnDutyToSlackGroup) {
+	forward, backward := s.SyncOptions.HandoverTimeFrameForward, s.SyncOptions.HandoverTimeFrameBackward
+	negative := false
+	for _, d := range []struct {
+		field    string
+		duration time.Duration
+	}{{"syncOptions.handoverTimeFrameForward", forward}, {"syncOptions.handoverTimeFrameBackward", backward}} {
+		if d.duration < 0 {
+			r.addAt(SeverityError, job, d.field, jobLine(cfg, job, d.field), "%s must not be negative", d.duration)
+			negative = true
+		}
+	}
+	if negative {
+		return
+	}
+
+	field := "syncOptions"
+	if forward == 0 && backward == 0 {
+		r.addAt(SeverityWarning, job, field, jobLine(cfg, job, field), "handover time frame is empty, only the users on shift at the time of the run are synced")
+		return
+	}
+	interval, ok := shortestInterval(s.CrontabExpressionForRepetition, s.Timezone)
+	if window := forward + backward; ok && window > interval {
+		r.addAt(SeverityWarning, job, field, jobLine(cfg, job, field), "handover time frame of %s is longer than the %s between the runs, users are synced for several runs before and after their shift", window, interval)
+	}
+}
+
+// shortestInterval returns the shortest time between the next runs of the crontab expression, false if it's invalid
+func shortestInterval(expression string, tz config.Timezone) (time.Duration, bool) {
+	schedule, err := jobs.ParseSchedule(expression, tz)
+	if err != nil {
+		return 0, false
+	}
+	var shortest time.Duration
+	prev := schedule.Next(time.Now())
+	for i := 0; i < 10; i++ {
+		next := schedule.Next(prev)
+		if d := next.Sub(prev); shortest == 0 || d < shortest {
+			shortest = d
+		}
+		prev = next
+	}
+	return shortest, shortest > 0
+}
+
+// checkUserMatching checks that the options required by the match strategies are set
+func checkUserMatching(r *Report, m config.UserMatching) {
+	if m.Uses(config.MatchEmailAlias) && len(m.DomainAliases) == 0 {
+		r.add(SeverityWarning, "slack", "userMatching.domainAliases", "not set, strategy '%s' only matches equal emails", config.MatchEmailAlias)
+	}
+	if m.Uses(config.MatchMappingFile) {
+		if m.MappingFile == "" {
+			r.add(SeverityError, "slack", "userMatching.mappingFile", "not set, required by strategy '%s'", config.MatchMappingFile)
+		} else if _, err := os.Stat(m.MappingFile); err != nil {
+			r.add(SeverityError, "slack", "userMatching.mappingFile", "%s", err.Error())
+		}
+	}
+	if m.Uses(config.MatchProfileField) && m.ProfileFieldID == "" {
+		r.add(SeverityError, "slack", "userMatching.profileFieldID", "not set, required by strategy '%s'", config.MatchProfileField)
+	}
+	if m.Uses(config.MatchName) && (m.NameSimilarity <= 0 || m.NameSimilarity > 1) {
+		r.add(SeverityError, "slack", "userMatching.nameSimilarity", "%v is not between 0 and 1", m.NameSimilarity)
+	}
+}
+
+func checkEmptyRotation(r *Report, job string, opts config.EmptyRotationOptions) {
+	switch {
+	case opts.Behavior == config.EmptyRotationFallback && opts.Fallback.IsEmpty():
+		r.add(SeverityError, job, "fallback", "not set, required by emptyRotation '%s'", opts.Behavior)
+	case opts.Behavior != config.EmptyRotationFallback && !opts.Fallback.IsEmpty():
+		r.add(SeverityWarning, job, "fallback", "ignored with emptyRotation '%s'", opts.Behavior)
+	}
+}
@@ -0,0 +1,137 @@
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sapcc/pagerduty2slack/internal/config"
+)
+
+func TestOfflineValid(t *testing.T) {
+	cfg := &config.Config{Jobs: config.JobsConfig{
+		ScheduleSync: []config.PagerdutyScheduleOnDutyToSlackGroup{{
+			CrontabExpressionForRepetition: "1 * * * *",
+			SyncOptions:                    config.ScheduleSyncOptions{HandoverTimeFrameForward: 30 * time.Minute, SyncStyle: config.FinalLayer},
+			ObjectsToSync:                  config.SyncObjects{SlackGroupHandle: "onduty-1", PagerdutyObjectIDs: []string{"P123"}},
+		}},
+		TeamSync: []config.PagerdutyTeamToSlackGroup{{
+			CrontabExpressionForRepetition: "0 9 * * 1-5",
+			ObjectsToSync:                  config.SyncObjects{SlackGroupHandle: "team-1", PagerdutyObjectIDs: []string{"P456"}},
+		}},
+	}}
+
+	r := Offline(cfg)
+
+	assert.False(t, r.HasErrors())
+	assert.Empty(t, r.Findings)
+	assert.Equal(t, 2, r.Jobs)
+}
+
+func TestOfflineInvalid(t *testing.T) {
+	cfg := &config.Config{Slack: config.SlackConfig{
+		UserMatching: config.UserMatching{Strategies: []config.MatchStrategy{config.MatchEmail, config.MatchMappingFile}},
+	}, Jobs: config.JobsConfig{
+		ScheduleSync: []config.PagerdutyScheduleOnDutyToSlackGroup{{
+			CrontabExpressionForRepetition: "1 * * *",
+			SyncOptions:                    config.ScheduleSyncOptions{HandoverTimeFrameBackward: 5 * time.Minute, SyncStyle: config.FinalLayer},
+			ObjectsToSync:                  config.SyncObjects{SlackGroupHandle: "onduty-1", PagerdutyObjectIDs: []string{"P123"}},
+		}},
+		TeamSync: []config.PagerdutyTeamToSlackGroup{{
+			CrontabExpressionForRepetition: "0 9 * * 1-5",
+			ObjectsToSync:                  config.SyncObjects{SlackGroupHandle: "OnDuty-1"},
+			EmptyRotation:                  config.EmptyRotationOptions{Behavior: config.EmptyRotationFallback},
+		}},
+	}}
+
+	r := Offline(cfg)
+
+	assert.True(t, r.HasErrors())
+	fields := []string{}
+	for _, f := range r.Findings {
+		fields = append(fields, f.Job+" "+f.Field)
+	}
+	assert.ElementsMatch(t, []string{
+		"pd-schedules-on-duty-to-slack-group[0] crontabExpressionForRepetition",
+		"pd-teams-to-slack-group[0] syncObjects.slackGroupHandle",
+		"pd-teams-to-slack-group[0] syncObjects.pdObjectIds",
+		"pd-teams-to-slack-group[0] fallback",
+		"slack userMatching.mappingFile",
+	}, fields)
+}
+
+func TestOfflineHandover(t *testing.T) {
+	tests := []struct {
+		name     string
+		crontab  string
+		forward  time.Duration
+		backward time.Duration
+		want     []string
+	}{
+		{"valid", "*/30 * * * *", 25 * time.Minute, 5 * time.Minute, []string{}},
+		{"negative", "*/30 * * * *", -time.Minute, -time.Minute, []string{
+			"error syncOptions.handoverTimeFrameForward",
+			"error syncOptions.handoverTimeFrameBackward",
+		}},
+		{"empty", "*/30 * * * *", 0, 0, []string{"warning syncOptions"}},
+		{"longer than interval", "*/30 * * * *", 30 * time.Minute, time.Minute, []string{"warning syncOptions"}},
+		{"longer than shortest interval", "0 8,20,21 * * *", 90 * time.Minute, 0, []string{"warning syncOptions"}},
+		{"invalid crontab", "1 * * *", 2 * time.Hour, 0, []string{"error crontabExpressionForRepetition"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Jobs: config.JobsConfig{ScheduleSync: []config.PagerdutyScheduleOnDutyToSlackGroup{{
+				CrontabExpressionForRepetition: tt.crontab,
+				SyncOptions:                    config.ScheduleSyncOptions{HandoverTimeFrameForward: tt.forward, HandoverTimeFrameBackward: tt.backward},
+				ObjectsToSync:                  config.SyncObjects{SlackGroupHandle: "onduty-1", PagerdutyObjectIDs: []string{"P123"}},
+			}}}}
+
+			r := Offline(cfg)
+
+			got := []string{}
+			for _, f := range r.Findings {
+				assert.Equal(t, "pd-schedules-on-duty-to-slack-group[0]", f.Job)
+				got = append(got, string(f.Severity)+" "+f.Field)
+			}
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+}
+
+func TestOfflineLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+jobs:
+  pd-schedules-on-duty-to-slack-group:
+    - crontabExpressionForRepetition: 1 * * *
+      syncOptions:
+        handoverTimeFrameForward: "-30m"
+      syncObjects:
+        slackGroupHandle: onduty-1
+        pdObjectIds: [P123]
+    - crontabExpressionForRepetition: 1 * * * *
+      syncObjects:
+        slackGroupHandle: onduty-2
+        pdObjectIds: [P456]
+`), 0o600))
+	cfg, err := config.ReadConfig(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	r := Offline(&cfg)
+
+	lines := map[string]int{}
+	for _, f := range r.Findings {
+		lines[f.Job+" "+f.Field] = f.Line
+	}
+	assert.Equal(t, map[string]int{
+		"pd-schedules-on-duty-to-slack-group[0] crontabExpressionForRepetition":       4,
+		"pd-schedules-on-duty-to-slack-group[0] syncOptions.handoverTimeFrameForward": 6,
+		"pd-schedules-on-duty-to-slack-group[1] syncOptions":                          10,
+	}, lines)
+	assert.Contains(t, r.String(), "pd-schedules-on-duty-to-slack-group[0] syncOptions.handoverTimeFrameForward (line 6):")
+}